@@ -32,6 +32,8 @@ type application struct {
 	mailer        mail.Client
 	authenticator auth.Authenticator
 	uploader      *services.S3Uploader
+	unsubscribe   mail.UnsubscribeSigner
+	oauth         *auth.OAuthFlow
 }
 
 type uploaderConfig struct {
@@ -40,7 +42,15 @@ type uploaderConfig struct {
 }
 
 type authConfig struct {
-	token tokenConfig
+	token     tokenConfig
+	mfa       mfaConfig
+	providers auth.ProvidersConfig
+}
+
+type mfaConfig struct {
+	// issuer is the account name shown by authenticator apps
+	issuer   string
+	tokenExp time.Duration
 }
 
 type tokenConfig struct {
@@ -50,13 +60,24 @@ type tokenConfig struct {
 }
 
 type mailConfig struct {
-	exp       time.Duration
-	fromEmail string
-	mailTrap  mailTrapConfig
+	exp               time.Duration
+	emailChangeExp    time.Duration
+	magicLinkExp      time.Duration
+	client            mail.Config
+	unsubscribeSecret string
+	webhooks          mailWebhooksConfig
+}
+
+type mailWebhooksConfig struct {
+	sendGridPublicKey string
+	resendSecret      string
 }
 
-type mailTrapConfig struct {
-	apiKey string
+type jobsConfig struct {
+	purgeInterval        time.Duration
+	unactivatedRetention time.Duration
+	outboxInterval       time.Duration
+	sessionsInterval     time.Duration
 }
 
 type dbConfig struct {
@@ -67,15 +88,28 @@ type dbConfig struct {
 }
 
 type config struct {
-	addr        string
-	db          dbConfig
-	env         string
-	apiURL      string
+	addr   string
+	db     dbConfig
+	env    string
+	apiURL string
+	// externalURL is the public URL of the API used in links sent by email
+	externalURL string
 	mail        mailConfig
 	frontendURL string
 	auth        authConfig
 	uploader    uploaderConfig
 	oauth       *oauth2.Config
+	session     sessionConfig
+	jobs        jobsConfig
+}
+
+type sessionConfig struct {
+	// secret signs the OAuth state cookies
+	secret   string
+	maxAge   time.Duration
+	secure   bool
+	httpOnly bool
+	sameSite http.SameSite
 }
 
 func (app *application) mount() http.Handler {
@@ -87,7 +121,7 @@ func (app *application) mount() http.Handler {
 	r.Use(middleware.Logger)
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{os.Getenv("FRONTEND_URL")},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: true,
@@ -101,26 +135,89 @@ func (app *application) mount() http.Handler {
 		// USERS ROUTES
 		r.Route("/users", func(r chi.Router) {
 			r.Put("/activate/{token}", app.activateUserTokenHandler)
+			r.Post("/activation/resend", app.resendActivationHandler)
+			r.Put("/email/confirm/{token}", app.confirmEmailChangeHandler)
+
+			r.Route("/me", func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
+				r.Get("/", app.getCurrentUserProfileHandler)
+				r.Patch("/", app.updateProfileHandler)
+				r.With(app.requireUserToken).Put("/password", app.changePasswordHandler)
+				r.With(app.requireUserToken).Post("/email", app.requestEmailChangeHandler)
+				r.Get("/usage", app.getStorageUsageHandler)
+				r.Get("/notifications", app.getNotificationPreferencesHandler)
+				r.Patch("/notifications", app.updateNotificationPreferencesHandler)
+
+				// account security, not available to API keys
+				r.Group(func(r chi.Router) {
+					r.Use(app.requireUserToken)
+					r.Get("/identities", app.getIdentitiesHandler)
+					r.Post("/identities/{provider}", app.linkIdentityHandler)
+					r.Delete("/identities/{provider}", app.unlinkIdentityHandler)
+					r.Get("/sessions", app.getSessionsHandler)
+					r.Delete("/sessions/{sessionID}", app.revokeSessionHandler)
+					r.Get("/api-keys", app.getAPIKeysHandler)
+					r.Post("/api-keys", app.createAPIKeyHandler)
+					r.Delete("/api-keys/{keyID}", app.revokeAPIKeyHandler)
+
+					r.Route("/mfa", func(r chi.Router) {
+						r.Post("/setup", app.setupMFAHandler)
+						r.Post("/enable", app.enableMFAHandler)
+						r.Post("/disable", app.disableMFAHandler)
+						r.Post("/recovery-codes", app.regenerateRecoveryCodesHandler)
+					})
+				})
+			})
 
 			r.Route("/{userID}", func(r chi.Router) {
 				// r.Use(app.AuthTokenMiddleware)
 				r.Get("/", app.getUserHandler)
+				r.With(app.AuthTokenMiddleware, app.requirePermission(store.PermissionUserManage)).Put("/quota", app.updateUserQuotaHandler)
 			})
 		})
 
+		// NOTIFICATIONS ROUTES
+		r.Route("/notifications", func(r chi.Router) {
+			r.Post("/unsubscribe/{token}", app.unsubscribeHandler)
+		})
+
+		// WEBHOOKS ROUTES
+		r.Route("/webhooks", func(r chi.Router) {
+			r.Post("/sendgrid", app.sendGridWebhookHandler)
+			r.Post("/resend", app.resendWebhookHandler)
+		})
+
 		// REVIEWS ROUTES
 		r.Route("/reviews", func(r chi.Router) {
 			r.Get("/{professorID}/tags", app.getTagsFromProfessorHandler)
 			r.With(app.AuthTokenMiddleware).Post("/{professorID}", app.createReviewHandler)
+			r.With(app.AuthTokenMiddleware, app.requirePermission(store.PermissionReviewModerate)).Delete("/moderation/{reviewID}", app.deleteReviewHandler)
 		})
 
 		// NOTES ROUTES
 		r.Route("/notes", func(r chi.Router) {
-			r.Use(app.AuthTokenMiddleware)
 			r.Get("/{professorID}", app.getNotesHandler)
-			/* r.With(app.RateLimitMiddleware). */ r.Post("/{professorID}", app.createNoteHandler)
-			r.Delete("/{noteID}", app.deleteNoteHandler)
-			r.Get("/{noteID}/view", app.getNoteByID)
+
+			// AUTH REQUIRED
+			r.Group(func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
+				/* r.With(app.RateLimitMiddleware). */ r.Post("/{professorID}", app.createNoteHandler)
+				r.Delete("/{noteID}", app.deleteNoteHandler)
+				r.Get("/{noteID}/view", app.getNoteByID)
+				r.Get("/{noteID}/archive", app.getNoteArchiveHandler)
+
+				r.Route("/{noteID}/comments", func(r chi.Router) {
+					r.Get("/", app.getCommentsHandler)
+					r.Post("/", app.createCommentHandler)
+
+					r.Route("/{commentID}", func(r chi.Router) {
+						r.Use(app.commentsContextMiddleware)
+						r.Get("/replies", app.getCommentRepliesHandler)
+						r.Patch("/", app.updateCommentHandler)
+						r.Delete("/", app.deleteCommentHandler)
+					})
+				})
+			})
 		})
 
 		r.Route("/professor", func(r chi.Router) {
@@ -140,21 +237,54 @@ func (app *application) mount() http.Handler {
 		// SCHOOL ROUTES
 		r.Route("/school", func(r chi.Router) {
 			// AUTH REQUIRED
-			r.With(app.AuthTokenMiddleware).Post("/{schoolID}", app.checkPostOwnership("admin", app.createProfessorHandler))
-			r.With(app.AuthTokenMiddleware).Post("/", app.checkPostOwnership("admin", app.createSchoolHandler))
+			r.With(app.AuthTokenMiddleware, app.requireSchoolPermission(store.PermissionProfessorCreate)).Post("/{schoolID}", app.createProfessorHandler)
+			r.With(app.AuthTokenMiddleware, app.requireSchoolPermission(store.PermissionReviewModerate)).Delete("/{schoolID}/reviews/{reviewID}", app.deleteReviewHandler)
+			r.With(app.AuthTokenMiddleware, app.requirePermission(store.PermissionSchoolCreate)).Post("/", app.createSchoolHandler)
 			r.Get("/{schoolID}", app.getSchoolHandler)
 			r.Get("/random", app.getRandomSchoolsHandler)
 		})
 
+		// ADMIN ROUTES
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(app.AuthTokenMiddleware)
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.requirePermission(store.PermissionMailManage))
+				r.Get("/outbox", app.getOutboxHandler)
+				r.Post("/outbox/{emailID}/retry", app.retryOutboxEmailHandler)
+				r.Get("/suppressions", app.getSuppressionsHandler)
+				r.Delete("/suppressions/{email}", app.deleteSuppressionHandler)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.requirePermission(store.PermissionUserManage))
+				r.Get("/roles", app.getRolesHandler)
+				r.Put("/users/{userID}/role", app.setUserRoleHandler)
+				r.Post("/users/{userID}/unlock", app.unlockUserHandler)
+				r.Post("/users/{userID}/suspension", app.suspendUserHandler)
+				r.Delete("/users/{userID}/suspension", app.liftSuspensionHandler)
+				r.Get("/users/{userID}/suspensions", app.getUserSuspensionsHandler)
+				r.Get("/schools/{schoolID}/members", app.getSchoolMembersHandler)
+				r.Put("/schools/{schoolID}/members/{userID}", app.setSchoolMemberHandler)
+				r.Delete("/schools/{schoolID}/members/{userID}", app.removeSchoolMemberHandler)
+			})
+
+			r.With(app.requirePermission(store.PermissionAuditRead)).Get("/audit", app.getAuditLogHandler)
+		})
+
 		// AUTH ROUTES
 		r.Route("/auth", func(r chi.Router) {
 			r.Get("/{provider}", app.beginAuthProviderCallback)
 			r.Get("/{provider}/callback", app.getAuthCallBackFunction)
 			r.Get("/logout/{provider}", app.logoutHandler)
 			r.Get("/oauth", app.getAuthCallback)
+			r.Get("/oauth/callback", app.oauthCallbackHandler)
 			r.Get("/me", app.getCurrentUser)
 			r.Post("/register", app.registerUserHandler)
 			r.Post("/login", app.loginUserHandler)
+			r.Post("/login/mfa", app.loginMFAHandler)
+			r.Post("/magic-link", app.requestMagicLinkHandler)
+			r.Post("/magic-link/{token}", app.magicLinkLoginHandler)
 			r.Get("/user", app.authUserHandler)
 		})
 	})
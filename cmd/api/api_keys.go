@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/bruno120805/project/internal/auth"
+	"github.com/bruno120805/project/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type CreateAPIKeyPayload struct {
+	Name   string   `json:"name" validate:"required,max=100"`
+	Scopes []string `json:"scopes" validate:"max=20,dive,max=100"`
+}
+
+type APIKeyWithSecret struct {
+	*store.APIKey
+	// Key is only returned when the key is created
+	Key string `json:"key"`
+}
+
+// authenticateAPIKey authenticates the request as the owner of the key, the
+// key is kept in the context to limit its permissions to its scopes.
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, plainKey string) {
+	ctx := r.Context()
+
+	key, err := app.store.APIKeys.GetByHash(ctx, auth.HashAPIKey(plainKey))
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.unauthorizedResponse(w, r, errors.New("invalid API key"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.store.Users.GetUserByID(ctx, key.UserID)
+	if err != nil {
+		app.unauthorizedResponse(w, r, err)
+		return
+	}
+
+	if !app.checkSuspension(w, r, user) {
+		return
+	}
+
+	ctx = context.WithValue(ctx, userKey, user)
+	ctx = context.WithValue(ctx, apiKeyKey, key)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// apiKeyFromContext returns the API key of the request, nil for requests
+// authenticated with a user token.
+func apiKeyFromContext(ctx context.Context) *store.APIKey {
+	key, _ := ctx.Value(apiKeyKey).(*store.APIKey)
+	return key
+}
+
+// requireUserToken rejects API keys on the endpoints that manage the account
+// itself, a leaked key must not be able to take it over.
+func (app *application) requireUserToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if apiKeyFromContext(r.Context()) != nil {
+			app.forbiddenResponse(w, r, errors.New("API keys can't be used on this endpoint"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// grantablePermissions returns the permissions of the user role and of its
+// school roles, the only scopes its keys can have.
+func (app *application) grantablePermissions(ctx context.Context, user *store.User) (map[string]bool, error) {
+	roleIDs, err := app.store.SchoolMemberships.ListRoleIDsByUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := map[string]bool{}
+	for _, roleID := range append(roleIDs, user.Role.ID) {
+		role, err := app.store.Roles.GetRoleByID(ctx, roleID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range role.Permissions {
+			permissions[p] = true
+		}
+	}
+
+	return permissions, nil
+}
+
+// GetAPIKeys godoc
+//
+//	@Summary		Fetches the API keys
+//	@Description	Fetches the API keys of the current user, the keys themselves are never returned
+//	@Tags			users
+//	@Produce		json
+//	@Success		200	{array}		store.APIKey
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/api-keys [get]
+func (app *application) getAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.getUserFromCtx(r)
+
+	keys, err := app.store.APIKeys.ListByUser(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, keys); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// CreateAPIKey godoc
+//
+//	@Summary		Creates an API key
+//	@Description	Creates a key for scripts, it is only shown in this response. Scopes must be permissions of the user
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		CreateAPIKeyPayload	true	"Key name and scopes"
+//	@Success		201		{object}	APIKeyWithSecret
+//	@Failure		400		{object}	error
+//	@Failure		403		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/api-keys [post]
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateAPIKeyPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.getUserFromCtx(r)
+	ctx := r.Context()
+
+	granted, err := app.grantablePermissions(ctx, user)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	scopes := []string{}
+	seen := map[string]bool{}
+	for _, scope := range payload.Scopes {
+		if !granted[scope] {
+			app.forbiddenResponse(w, r, fmt.Errorf("you don't have the permission %s", scope))
+			return
+		}
+
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+
+	plainKey, prefix, err := auth.GenerateAPIKey()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	key := &store.APIKey{
+		UserID: user.ID,
+		Name:   payload.Name,
+		Prefix: prefix,
+		Scopes: scopes,
+	}
+
+	if err := app.store.APIKeys.Create(ctx, key, auth.HashAPIKey(plainKey)); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, APIKeyWithSecret{APIKey: key, Key: plainKey}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// RevokeAPIKey godoc
+//
+//	@Summary		Revokes an API key
+//	@Description	Deletes an API key of the current user
+//	@Tags			users
+//	@Param			keyID	path	int	true	"API key ID"
+//	@Success		204
+//	@Failure		404	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/api-keys/{keyID} [delete]
+func (app *application) revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	keyID, err := strconv.ParseInt(chi.URLParam(r, "keyID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.getUserFromCtx(r)
+
+	if err := app.store.APIKeys.Revoke(r.Context(), user.ID, keyID); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,22 +1,22 @@
 package main
 
 import (
-	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/bruno120805/project/internal/auth"
 	"github.com/bruno120805/project/internal/mail"
 	"github.com/bruno120805/project/internal/store"
-	"github.com/go-chi/chi/v5"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/markbates/goth"
 	"github.com/markbates/goth/gothic"
-	"golang.org/x/oauth2"
 )
 
 type RegisterUserPayload struct {
@@ -59,6 +59,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	user := &store.User{
 		Username: payload.Username,
 		Email:    payload.Email,
+		Locale:   mail.MatchLocale(r.Header.Get("Accept-Language")),
 	}
 
 	// hash the user password
@@ -76,8 +77,14 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	hash := sha256.Sum256([]byte(plainToken))
 	hashedToken := hex.EncodeToString(hash[:])
 
-	// store the user
-	if err = app.store.Users.CreateAndInvite(ctx, user, hashedToken, app.config.mail.exp); err != nil {
+	welcome, err := newWelcomeEmail(app.config.frontendURL, user, plainToken)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// store the user, the welcome email is sent by the outbox dispatcher
+	if err = app.store.Users.CreateAndInvite(ctx, user, hashedToken, app.config.mail.exp, welcome); err != nil {
 		switch err {
 		case store.ErrDuplicateEmail:
 			app.badRequestResponse(w, r, err)
@@ -94,9 +101,15 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		Token: plainToken,
 	}
 
-	activationURL := fmt.Sprintf("%s/activate/%s", app.config.frontendURL, plainToken)
+	if err := app.jsonResponse(w, http.StatusCreated, userWithToken); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+func newWelcomeEmail(frontendURL string, user *store.User, plainToken string) (*store.OutboxEmail, error) {
+	activationURL := fmt.Sprintf("%s/activate/%s", frontendURL, plainToken)
 
-	isProdEnv := app.config.env == "production"
 	vars := struct {
 		Username      string
 		ActivationURL string
@@ -105,25 +118,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		ActivationURL: activationURL,
 	}
 
-	// send mail
-	status, err := app.mailer.Send(mail.UserWelcomeTemplate, user.Username, user.Email, vars, !isProdEnv)
-	if err != nil {
-		app.logger.Errorw("error sending welcome email", "error", err)
-
-		// rollback user creation if emails fails (SAGA pattern)
-		if err = app.store.Users.Delete(ctx, user.ID); err != nil {
-			app.logger.Errorw("error deleting user", "error", err)
-		}
-		app.internalServerError(w, r, err)
-		return
-	}
-
-	app.logger.Infow("Email sent", "status code", status)
-
-	if err := app.jsonResponse(w, http.StatusCreated, userWithToken); err != nil {
-		app.internalServerError(w, r, err)
-		return
-	}
+	return store.NewOutboxEmail(mail.UserWelcomeTemplate, user.Locale, user.Username, user.Email, vars)
 }
 
 type LoginUserPayload struct {
@@ -158,12 +153,28 @@ func (app *application) loginUserHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	ctx := r.Context()
+	ip := clientIP(r)
+
+	wait, err := app.loginRetryAfter(ctx, payload.Email, ip)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if wait > 0 {
+		app.loginThrottledResponse(w, r, wait)
+		return
+	}
 
 	// fetch the user (check if the user exists) from the payload
 	user, err := app.store.Users.GetUserByEmail(ctx, payload.Email)
 	if err != nil {
 		switch err {
 		case store.ErrNotFound:
+			if err := app.recordLoginFailure(ctx, payload.Email, ip, nil); err != nil {
+				app.internalServerError(w, r, err)
+				return
+			}
 			app.unauthorizedResponse(w, r, err)
 		default:
 			app.internalServerError(w, r, err)
@@ -172,26 +183,69 @@ func (app *application) loginUserHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	if err = user.Password.Compare(payload.Password); err != nil {
+		if err := app.recordLoginFailure(ctx, payload.Email, ip, user); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
 		app.unauthorizedResponse(w, r, err)
 		return
 	}
 
-	// generate a new token
-	claims := jwt.MapClaims{
-		"sub": user.ID,
-		"exp": time.Now().Add(app.config.auth.token.exp).Unix(),
-		"iat": time.Now().Unix(),
-		"nbf": time.Now().Unix(),
-		"iss": app.config.auth.token.iss,
-		"aud": app.config.auth.token.iss,
+	// users with MFA only reset the failures once the code is verified
+	if !user.MFAEnabled {
+		if err := app.store.LoginThrottles.Reset(ctx, store.ThrottleAccount, payload.Email); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
 	}
 
-	token, err := app.authenticator.GenerateToken(claims)
+	// only tell the account is not activated to whoever knows the password
+	if !user.IsActive {
+		app.accountNotActivatedResponse(w, r, store.ErrNotActivated)
+		return
+	}
+
+	app.loginResponse(w, r, user)
+}
+
+// loginResponse answers a successful first factor with the user token, or
+// with the MFA challenge for users with MFA enabled.
+func (app *application) loginResponse(w http.ResponseWriter, r *http.Request, user *store.User) {
+	if !app.checkSuspension(w, r, user) {
+		return
+	}
+
+	// users with MFA get a challenge token to exchange with a code
+	if user.MFAEnabled {
+		mfaToken, err := app.generateMFAToken(user)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		resp := map[string]interface{}{
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+		}
+
+		if err := app.jsonResponse(w, http.StatusOK, resp); err != nil {
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	// generate a new token
+	token, err := app.generateUserToken(user)
 	if err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
 
+	if err := app.startSession(w, r, user); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
 	resp := map[string]interface{}{
 		"token": token,
 		"user":  user,
@@ -203,6 +257,50 @@ func (app *application) loginUserHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// startSession logs the user in the session cookie, the session of the
+// request is renewed first so its token is never reused across logins.
+func (app *application) startSession(w http.ResponseWriter, r *http.Request, user *store.User) error {
+	session, err := gothic.Store.Get(r, "session")
+	if err != nil {
+		return err
+	}
+
+	if err := app.store.Sessions.Renew(r.Context(), session); err != nil {
+		return err
+	}
+
+	// Guardamos el usuario en la sesion
+	session.Values["userID"] = user.ID
+	session.Values["username"] = user.Username
+	session.Values["email"] = user.Email
+
+	// Guarda sesión (esto genera la cookie)
+	return session.Save(r, w)
+}
+
+// generateUserToken issues the JWT used to authenticate the user, "ver" ties
+// the token to the user token version so it can be revoked.
+func (app *application) generateUserToken(user *store.User) (string, error) {
+	claims := jwt.MapClaims{
+		"sub": user.ID,
+		"ver": user.TokenVersion,
+		"exp": time.Now().Add(app.config.auth.token.exp).Unix(),
+		"iat": time.Now().Unix(),
+		"nbf": time.Now().Unix(),
+		"iss": app.config.auth.token.iss,
+		"aud": app.config.auth.token.iss,
+	}
+
+	return app.authenticator.GenerateToken(claims)
+}
+
+// tokenVersion returns the version the token was issued with, tokens issued
+// before versions existed count as version 0.
+func tokenVersion(claims jwt.MapClaims) int {
+	ver, _ := claims["ver"].(float64)
+	return int(ver)
+}
+
 func (app *application) authUserHandler(w http.ResponseWriter, r *http.Request) {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
@@ -222,6 +320,11 @@ func (app *application) authUserHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	claims := jwtToken.Claims.(jwt.MapClaims)
+	if tokenType(claims) != "" {
+		app.unauthorizedResponse(w, r, fmt.Errorf("not a user token"))
+		return
+	}
+
 	userID, err := strconv.ParseInt(fmt.Sprintf("%v", claims["sub"]), 10, 64)
 	if err != nil {
 		app.unauthorizedResponse(w, r, err)
@@ -240,6 +343,15 @@ func (app *application) authUserHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if tokenVersion(claims) != user.TokenVersion {
+		app.unauthorizedResponse(w, r, fmt.Errorf("token has been revoked"))
+		return
+	}
+
+	if !app.checkSuspension(w, r, user) {
+		return
+	}
+
 	if err := app.jsonResponse(w, http.StatusOK, user); err != nil {
 		app.internalServerError(w, r, err)
 		return
@@ -247,12 +359,16 @@ func (app *application) authUserHandler(w http.ResponseWriter, r *http.Request)
 }
 
 func (app *application) getAuthCallBackFunction(w http.ResponseWriter, r *http.Request) {
-	provider := chi.URLParam(r, "provider")
-	r = r.WithContext(context.WithValue(r.Context(), "provider", provider))
+	r = withProvider(r)
 
 	user, err := gothic.CompleteUserAuth(w, r)
 	if err != nil {
-		app.internalServerError(w, r, err)
+		app.unauthorizedResponse(w, r, err)
+		return
+	}
+
+	if user.UserID == "" {
+		app.badRequestResponse(w, r, errors.New("the provider did not return the account"))
 		return
 	}
 
@@ -262,45 +378,74 @@ func (app *application) getAuthCallBackFunction(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	usr := &store.User{
-		Username: user.FirstName,
-		Email:    user.Email,
-		IsActive: true,
+	identity := &store.Identity{
+		Provider:       user.Provider,
+		ProviderUserID: user.UserID,
+		Email:          user.Email,
+		EmailVerified:  app.emailVerified(user),
 	}
 
-	ctx := r.Context()
+	// the user started linking the provider from the profile
+	_, hadIntent := session.Values["link_user_id"]
+	linkUserID, linking := takeLinkIntent(session, user.Provider)
+	if hadIntent {
+		if err := session.Save(r, w); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+	}
+
+	if linking {
+		app.linkIdentityCallback(w, r, linkUserID, identity)
+		return
+	}
 
-	if _, err = app.store.Users.GetUserByID(ctx, usr.ID); err != nil {
-		err = app.store.Users.CreateOrUpdateUser(ctx, usr)
+	app.loginWithIdentity(w, r, user, identity)
+}
+
+// loginWithIdentity logs the user in with a provider account and redirects
+// to the frontend with the token, or the MFA challenge.
+func (app *application) loginWithIdentity(w http.ResponseWriter, r *http.Request, user goth.User, identity *store.Identity) {
+	usr, err := app.userFromIdentity(r, user, identity)
+	if err != nil {
+		switch err {
+		case errUnverifiedEmail:
+			app.forbiddenResponse(w, r, err)
+		case errEmailTaken, store.ErrDuplicateUsername:
+			app.conflictResponse(w, r, err)
+		case store.ErrNotFound:
+			app.unauthorizedResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkSuspension(w, r, usr) {
+		return
+	}
+
+	// the provider replaces the password but not the second factor
+	if usr.MFAEnabled {
+		mfaToken, err := app.generateMFAToken(usr)
 		if err != nil {
-			app.badRequestResponse(w, r, err)
+			app.internalServerError(w, r, err)
 			return
 		}
-	}
 
-	// Generamos un nuevo token para asi autenticar al usuario
-	claims := jwt.MapClaims{
-		"sub": usr.ID,
-		"exp": time.Now().Add(app.config.auth.token.exp).Unix(),
-		"iat": time.Now().Unix(),
-		"nbf": time.Now().Unix(),
-		"iss": app.config.auth.token.iss,
-		"aud": app.config.auth.token.iss,
+		redirectURL := fmt.Sprintf("%s?mfa_token=%s", app.config.frontendURL, mfaToken)
+		http.Redirect(w, r, redirectURL, http.StatusTemporaryRedirect)
+		return
 	}
 
-	token, err := app.authenticator.GenerateToken(claims)
+	// Generamos un nuevo token para asi autenticar al usuario
+	token, err := app.generateUserToken(usr)
 	if err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
 
-	// Guardamos el usuario en la sesion
-	session.Values["userID"] = usr.ID
-	session.Values["username"] = usr.Username
-	session.Values["email"] = usr.Email
-
-	// Guarda sesión (esto genera la cookie)
-	if err := session.Save(r, w); err != nil {
+	if err := app.startSession(w, r, usr); err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
@@ -323,17 +468,52 @@ func (app *application) logoutHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) beginAuthProviderCallback(w http.ResponseWriter, r *http.Request) {
-	if _, err := gothic.CompleteUserAuth(w, r); err != nil {
-		gothic.BeginAuthHandler(w, r)
-	}
-
+	r = withProvider(r)
+	gothic.BeginAuthHandler(w, r)
 }
 
+// getAuthCallback starts the Google authorization code flow, the state and
+// PKCE verifier are kept in a signed cookie until the callback.
 func (app *application) getAuthCallback(w http.ResponseWriter, r *http.Request) {
-	url := app.config.oauth.AuthCodeURL("state", oauth2.AccessTypeOffline)
+	url, err := app.oauth.Begin(w)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
 	http.Redirect(w, r, url, http.StatusFound)
 }
 
+// oauthCallbackHandler validates the state, exchanges the code and logs in
+// with the Google account.
+func (app *application) oauthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := app.oauth.Exchange(w, r)
+	if err != nil {
+		app.unauthorizedResponse(w, r, err)
+		return
+	}
+
+	user, err := app.oauth.UserInfo(r.Context(), auth.ProviderGoogle, token)
+	if err != nil {
+		app.unauthorizedResponse(w, r, err)
+		return
+	}
+
+	if user.UserID == "" {
+		app.badRequestResponse(w, r, errors.New("the provider did not return the account"))
+		return
+	}
+
+	identity := &store.Identity{
+		Provider:       user.Provider,
+		ProviderUserID: user.UserID,
+		Email:          user.Email,
+		EmailVerified:  app.emailVerified(user),
+	}
+
+	app.loginWithIdentity(w, r, user, identity)
+}
+
 func (app *application) getCurrentUser(w http.ResponseWriter, r *http.Request) {
 	session, err := gothic.Store.Get(r, "session")
 	if err != nil {
@@ -341,14 +521,32 @@ func (app *application) getCurrentUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID, _ := session.Values["userID"].(int64)
-	username, _ := session.Values["username"].(string)
-	email, _ := session.Values["email"].(string)
+	// the session only proves who logged in, the user must still exist
+	userID, ok := session.Values["userID"].(int64)
+	if !ok {
+		app.unauthorizedResponse(w, r, errors.New("user is not authenticated"))
+		return
+	}
+
+	user, err := app.store.Users.GetUserByID(r.Context(), userID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.unauthorizedResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkSuspension(w, r, user) {
+		return
+	}
 
 	resp := map[string]interface{}{
-		"userID":   userID,
-		"username": username,
-		"email":    email,
+		"userID":   user.ID,
+		"username": user.Username,
+		"email":    user.Email,
 	}
 
 	if err := app.jsonResponse(w, http.StatusOK, resp); err != nil {
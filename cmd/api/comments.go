@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/bruno120805/project/internal/mail"
+	"github.com/bruno120805/project/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type commentKey string
+
+const commentCtx commentKey = "comment"
+
+type CreateCommentPayload struct {
+	Content  string `json:"content" validate:"required,max=2000"`
+	ParentID *int64 `json:"parent_id"`
+}
+
+type UpdateCommentPayload struct {
+	Content string `json:"content" validate:"required,max=2000"`
+}
+
+// CreateComment godoc
+//
+//	@Summary		Creates a comment on a note
+//	@Description	Creates a comment on a note, set parent_id to reply to another comment
+//	@Tags			comments
+//	@Accept			json
+//	@Produce		json
+//	@Param			noteID	path		int						true	"Note ID"
+//	@Param			payload	body		CreateCommentPayload	true	"Comment payload"
+//	@Success		201		{object}	store.Comment
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/notes/{noteID}/comments [post]
+func (app *application) createCommentHandler(w http.ResponseWriter, r *http.Request) {
+	noteID, err := strconv.ParseInt(chi.URLParam(r, "noteID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var payload CreateCommentPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	note, err := app.store.Notes.GetNoteByID(ctx, noteID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	// replies must belong to the same note as their parent
+	if payload.ParentID != nil {
+		parent, err := app.store.Comments.GetByID(ctx, *payload.ParentID)
+		if err != nil {
+			switch err {
+			case store.ErrNotFound:
+				app.notFoundResponse(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		if parent.NoteID != noteID {
+			app.badRequestResponse(w, r, errors.New("parent comment belongs to another note"))
+			return
+		}
+	}
+
+	user := app.getUserFromCtx(r)
+
+	comment := &store.Comment{
+		NoteID:   noteID,
+		UserID:   user.ID,
+		Username: user.Username,
+		ParentID: payload.ParentID,
+		Content:  payload.Content,
+	}
+
+	if err := app.store.Comments.Create(ctx, comment); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// the comment is already saved, failing to notify the author is only logged
+	if err := app.notifyNoteComment(ctx, note, comment); err != nil {
+		app.logger.Errorw("error queueing note comment notification", "comment", comment.ID, "error", err)
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, comment); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// GetComments godoc
+//
+//	@Summary		Fetches the comments of a note
+//	@Description	Fetches the top level comments of a note paginated
+//	@Tags			comments
+//	@Accept			json
+//	@Produce		json
+//	@Param			noteID	path		int		true	"Note ID"
+//	@Param			limit	query		int		false	"Limit"
+//	@Param			offset	query		int		false	"Offset"
+//	@Param			sort	query		string	false	"Sort"
+//	@Success		200		{array}		store.Comment
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/notes/{noteID}/comments [get]
+func (app *application) getCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	noteID, err := strconv.ParseInt(chi.URLParam(r, "noteID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	fq, err := parseCommentsFeedQuery(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	comments, err := app.store.Comments.GetByNoteID(ctx, noteID, fq)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, comments); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// GetCommentReplies godoc
+//
+//	@Summary		Fetches the replies of a comment
+//	@Description	Fetches the replies of a comment paginated
+//	@Tags			comments
+//	@Accept			json
+//	@Produce		json
+//	@Param			noteID		path		int		true	"Note ID"
+//	@Param			commentID	path		int		true	"Comment ID"
+//	@Param			limit		query		int		false	"Limit"
+//	@Param			offset		query		int		false	"Offset"
+//	@Param			sort		query		string	false	"Sort"
+//	@Success		200			{array}		store.Comment
+//	@Failure		400			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		500			{object}	error
+//	@Router			/notes/{noteID}/comments/{commentID}/replies [get]
+func (app *application) getCommentRepliesHandler(w http.ResponseWriter, r *http.Request) {
+	comment := getCommentFromCtx(r)
+
+	fq, err := parseCommentsFeedQuery(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	replies, err := app.store.Comments.GetReplies(ctx, comment.ID, fq)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, replies); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// UpdateComment godoc
+//
+//	@Summary		Updates a comment
+//	@Description	Updates the content of a comment, only its author can
+//	@Tags			comments
+//	@Accept			json
+//	@Produce		json
+//	@Param			noteID		path		int						true	"Note ID"
+//	@Param			commentID	path		int						true	"Comment ID"
+//	@Param			payload		body		UpdateCommentPayload	true	"Comment payload"
+//	@Success		200			{object}	store.Comment
+//	@Failure		400			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/notes/{noteID}/comments/{commentID} [patch]
+func (app *application) updateCommentHandler(w http.ResponseWriter, r *http.Request) {
+	comment := getCommentFromCtx(r)
+
+	if comment.IsDeleted {
+		app.notFoundResponse(w, r, store.ErrNotFound)
+		return
+	}
+
+	user := app.getUserFromCtx(r)
+
+	// only the author can edit the content of a comment
+	if comment.UserID != user.ID {
+		app.forbiddenResponse(w, r, fmt.Errorf("forbidden"))
+		return
+	}
+
+	var payload UpdateCommentPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	comment.Content = payload.Content
+
+	ctx := r.Context()
+
+	if err := app.store.Comments.Update(ctx, comment); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, comment); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// DeleteComment godoc
+//
+//	@Summary		Deletes a comment
+//	@Description	Deletes a comment, its author or a moderator can. The replies are kept
+//	@Tags			comments
+//	@Param			noteID		path	int	true	"Note ID"
+//	@Param			commentID	path	int	true	"Comment ID"
+//	@Success		204
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/notes/{noteID}/comments/{commentID} [delete]
+func (app *application) deleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	comment := getCommentFromCtx(r)
+
+	ctx := r.Context()
+	user := app.getUserFromCtx(r)
+
+	// the author or a moderator can delete a comment
+	if comment.UserID != user.ID {
+		allowed, err := app.hasPermission(ctx, user, store.PermissionCommentDeleteAny)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		if !allowed {
+			app.forbiddenResponse(w, r, fmt.Errorf("forbidden"))
+			return
+		}
+	}
+
+	if err := app.store.Comments.Delete(ctx, comment.ID); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// notifyNoteComment queues an email to the author of the note, the
+// dispatcher drops it if the author disabled note comment notifications.
+func (app *application) notifyNoteComment(ctx context.Context, note *store.Note, comment *store.Comment) error {
+	if note.UserID == comment.UserID {
+		return nil
+	}
+
+	author, err := app.store.Users.GetUserByID(ctx, note.UserID)
+	if err != nil {
+		if err == store.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+
+	vars := struct {
+		Username      string
+		CommenterName string
+		NoteTitle     string
+		Comment       string
+		NoteURL       string
+	}{
+		Username:      author.Username,
+		CommenterName: comment.Username,
+		NoteTitle:     note.Title,
+		Comment:       comment.Content,
+		NoteURL:       fmt.Sprintf("%s/notes/%d", app.config.frontendURL, note.ID),
+	}
+
+	email, err := store.NewOutboxEmail(mail.NoteCommentTemplate, author.Locale, author.Username, author.Email, vars)
+	if err != nil {
+		return err
+	}
+	email.UserID = &author.ID
+	email.Category = store.NotificationNoteComments
+
+	return app.store.Outbox.Enqueue(ctx, email)
+}
+
+// commentsContextMiddleware loads the comment from the URL and checks it
+// belongs to the note in the same URL.
+func (app *application) commentsContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		noteID, err := strconv.ParseInt(chi.URLParam(r, "noteID"), 10, 64)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		commentID, err := strconv.ParseInt(chi.URLParam(r, "commentID"), 10, 64)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		ctx := r.Context()
+
+		comment, err := app.store.Comments.GetByID(ctx, commentID)
+		if err != nil {
+			switch err {
+			case store.ErrNotFound:
+				app.notFoundResponse(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		if comment.NoteID != noteID {
+			app.notFoundResponse(w, r, store.ErrNotFound)
+			return
+		}
+
+		ctx = context.WithValue(ctx, commentCtx, comment)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getCommentFromCtx(r *http.Request) *store.Comment {
+	comment, _ := r.Context().Value(commentCtx).(*store.Comment)
+	return comment
+}
+
+func parseCommentsFeedQuery(r *http.Request) (store.PaginatedFeedQuery, error) {
+	fq := store.PaginatedFeedQuery{
+		Limit:  20,
+		Offset: 0,
+		Sort:   "asc",
+	}
+
+	fq, err := fq.Parse(r)
+	if err != nil {
+		return fq, err
+	}
+
+	if err := Validate.Struct(fq); err != nil {
+		return fq, err
+	}
+
+	return fq, nil
+}
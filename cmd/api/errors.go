@@ -1,7 +1,10 @@
 package main
 
 import (
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func (app *application) internalServerError(w http.ResponseWriter, r *http.Request, err error) {
@@ -55,6 +58,43 @@ func (app *application) forbiddenResponse(w http.ResponseWriter, r *http.Request
 	writeJSONError(w, http.StatusForbidden, "forbidden")
 }
 
+func (app *application) accountNotActivatedResponse(w http.ResponseWriter, r *http.Request, err error) {
+
+	app.logger.Warnf("account not activated error", "method", r.Method, "path", r.URL.Path, "error", err)
+
+	writeJSONError(w, http.StatusForbidden, err.Error())
+}
+
+func (app *application) accountSuspendedResponse(w http.ResponseWriter, r *http.Request, err error) {
+
+	app.logger.Warnf("account suspended error", "method", r.Method, "path", r.URL.Path, "error", err)
+
+	writeJSONError(w, http.StatusForbidden, err.Error())
+}
+
+func (app *application) mfaRequiredResponse(w http.ResponseWriter, r *http.Request, err error) {
+
+	app.logger.Warnf("mfa required error", "method", r.Method, "path", r.URL.Path, "error", err)
+
+	writeJSONError(w, http.StatusForbidden, "two-factor authentication must be enabled for this account")
+}
+
+func (app *application) quotaExceededResponse(w http.ResponseWriter, r *http.Request, err error) {
+
+	app.logger.Warnf("quota exceeded error", "method", r.Method, "path", r.URL.Path, "error", err)
+
+	writeJSONError(w, http.StatusRequestEntityTooLarge, err.Error())
+}
+
+func (app *application) loginThrottledResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+
+	app.logger.Warnf("login throttled error", "method", r.Method, "path", r.URL.Path)
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	writeJSONError(w, http.StatusTooManyRequests, "too many failed login attempts, try again later")
+}
+
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter string) {
 
 	app.logger.Warnf("rate limit error", "method", r.Method, "path", r.URL.Path)
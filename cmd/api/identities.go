@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bruno120805/project/internal/mail"
+	"github.com/bruno120805/project/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/sessions"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/gothic"
+)
+
+const (
+	// linkIntentExp is how long the session remembers that the user asked to
+	// link a provider.
+	linkIntentExp = 10 * time.Minute
+
+	// oauthUsernameAttempts bounds the retries with a random suffix when the
+	// username taken from the provider already exists.
+	oauthUsernameAttempts = 5
+)
+
+var (
+	errUnverifiedEmail = errors.New("the provider did not verify the email of this account")
+	errLastLoginMethod = errors.New("set a password or link another provider before unlinking the last one")
+	errEmailTaken      = errors.New("an account with this email exists, log in and link the provider from your profile")
+)
+
+type IdentityLink struct {
+	URL string `json:"url"`
+}
+
+// withProvider puts the provider of the route where gothic looks for it.
+func withProvider(r *http.Request) *http.Request {
+	provider := chi.URLParam(r, "provider")
+	return r.WithContext(context.WithValue(r.Context(), "provider", provider))
+}
+
+// emailVerified reports whether the provider vouches for the email of the
+// account, providers that don't say so are not trusted unless configured.
+func (app *application) emailVerified(user goth.User) bool {
+	if app.config.auth.providers.TrustsEmail(user.Provider) {
+		return true
+	}
+
+	for _, key := range []string{"verified_email", "email_verified"} {
+		switch v := user.RawData[key].(type) {
+		case bool:
+			return v
+		case string:
+			verified, _ := strconv.ParseBool(v)
+			return verified
+		}
+	}
+
+	return false
+}
+
+// userFromIdentity logs in with the identity, or creates a new account the
+// first time. Identities are never attached to an existing account here,
+// that takes the explicit link from the profile of the logged in user.
+func (app *application) userFromIdentity(r *http.Request, gothUser goth.User, identity *store.Identity) (*store.User, error) {
+	ctx := r.Context()
+
+	existing, err := app.store.Identities.GetByProvider(ctx, identity.Provider, identity.ProviderUserID)
+	switch err {
+	case nil:
+		identity.ID = existing.ID
+		if err := app.store.Identities.TouchLogin(ctx, identity); err != nil {
+			return nil, err
+		}
+
+		return app.store.Users.GetUserByID(ctx, existing.UserID)
+	case store.ErrNotFound:
+	default:
+		return nil, err
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, errUnverifiedEmail
+	}
+
+	_, err = app.store.Users.GetUserByEmail(ctx, identity.Email)
+	switch err {
+	case nil:
+		return nil, errEmailTaken
+	case store.ErrNotFound:
+	default:
+		return nil, err
+	}
+
+	user := &store.User{
+		Username: oauthUsername(gothUser),
+		Email:    identity.Email,
+		Locale:   mail.MatchLocale(r.Header.Get("Accept-Language")),
+	}
+
+	for attempt := 0; ; attempt++ {
+		err = app.store.Users.CreateWithIdentity(ctx, user, identity)
+		if err != store.ErrDuplicateUsername || attempt == oauthUsernameAttempts {
+			break
+		}
+
+		user.Username = fmt.Sprintf("%s-%s", oauthUsername(gothUser), randomDigits(4))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// oauthUsername derives a username from the provider profile.
+func oauthUsername(user goth.User) string {
+	base := user.NickName
+	if base == "" {
+		base = user.FirstName
+	}
+	if base == "" {
+		base, _, _ = strings.Cut(user.Email, "@")
+	}
+
+	username := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			return r
+		default:
+			return -1
+		}
+	}, base)
+
+	if len(username) > 50 {
+		username = username[:50]
+	}
+	if username == "" {
+		username = "user"
+	}
+
+	return username
+}
+
+func randomDigits(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		d, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			panic(err)
+		}
+		b.WriteString(d.String())
+	}
+
+	return b.String()
+}
+
+// linkIdentityCallback finishes linking the provider started from the
+// profile of the user.
+func (app *application) linkIdentityCallback(w http.ResponseWriter, r *http.Request, userID int64, identity *store.Identity) {
+	ctx := r.Context()
+
+	identity.UserID = userID
+	if err := app.store.Identities.Link(ctx, identity); err != nil {
+		switch err {
+		case store.ErrIdentityTaken, store.ErrProviderAlreadyLinked:
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	entry, err := store.NewAuditEntry(store.AuditIdentityLinked, &userID, &userID, clientIP(r), map[string]any{
+		"provider": identity.Provider,
+		"email":    identity.Email,
+	})
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Audit.Record(ctx, entry); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	redirectURL := fmt.Sprintf("%s?linked=%s", app.config.frontendURL, url.QueryEscape(identity.Provider))
+	http.Redirect(w, r, redirectURL, http.StatusTemporaryRedirect)
+}
+
+// GetIdentities godoc
+//
+//	@Summary		Fetches the linked providers
+//	@Description	Fetches the OAuth providers the current user can log in with
+//	@Tags			users
+//	@Produce		json
+//	@Success		200	{array}		store.Identity
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/identities [get]
+func (app *application) getIdentitiesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.getUserFromCtx(r)
+
+	identities, err := app.store.Identities.ListByUser(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, identities); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// LinkIdentity godoc
+//
+//	@Summary		Starts linking a provider
+//	@Description	Remembers in the session cookie that the current user links the provider and returns the URL that starts it, the request must send the cookies
+//	@Tags			users
+//	@Produce		json
+//	@Param			provider	path		string	true	"Provider"
+//	@Success		200			{object}	IdentityLink
+//	@Failure		404			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/identities/{provider} [post]
+func (app *application) linkIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	provider := chi.URLParam(r, "provider")
+	if _, err := goth.GetProvider(provider); err != nil {
+		app.notFoundResponse(w, r, err)
+		return
+	}
+
+	user := app.getUserFromCtx(r)
+
+	// the intent is only set by an authenticated request, never by a link a
+	// third party can make the browser open
+	session, err := gothic.Store.Get(r, "session")
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	session.Values["link_user_id"] = user.ID
+	session.Values["link_provider"] = provider
+	session.Values["link_expires"] = time.Now().Add(linkIntentExp).Unix()
+
+	if err := session.Save(r, w); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	link := IdentityLink{
+		URL: fmt.Sprintf("%s/v1/auth/%s", app.config.externalURL, url.PathEscape(provider)),
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, link); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// UnlinkIdentity godoc
+//
+//	@Summary		Unlinks a provider
+//	@Description	Removes the provider from the login methods of the current user
+//	@Tags			users
+//	@Param			provider	path	string	true	"Provider"
+//	@Success		204
+//	@Failure		404	{object}	error
+//	@Failure		409	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/identities/{provider} [delete]
+func (app *application) unlinkIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	provider := chi.URLParam(r, "provider")
+	user := app.getUserFromCtx(r)
+	ctx := r.Context()
+
+	// without a password the user must keep a way to log in
+	if !user.Password.IsSet() {
+		identities, err := app.store.Identities.ListByUser(ctx, user.ID)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		if len(identities) <= 1 {
+			app.conflictResponse(w, r, errLastLoginMethod)
+			return
+		}
+	}
+
+	if err := app.store.Identities.Unlink(ctx, user.ID, provider); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// takeLinkIntent returns the user that asked to link the provider in this
+// session, if any, and forgets the intent so it is only used once.
+func takeLinkIntent(session *sessions.Session, provider string) (int64, bool) {
+	userID, ok := session.Values["link_user_id"].(int64)
+	linkProvider, _ := session.Values["link_provider"].(string)
+	expires, _ := session.Values["link_expires"].(int64)
+
+	delete(session.Values, "link_user_id")
+	delete(session.Values, "link_provider")
+	delete(session.Values, "link_expires")
+
+	if !ok || linkProvider != provider || time.Now().Unix() > expires {
+		return 0, false
+	}
+
+	return userID, true
+}
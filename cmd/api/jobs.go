@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// purgeUnactivatedUsers periodically removes the accounts that never
+// activated, so their email and username can be registered again.
+func (app *application) purgeUnactivatedUsers(ctx context.Context) {
+	ticker := time.NewTicker(app.config.jobs.purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := app.store.Users.DeleteUnactivated(ctx, app.config.jobs.unactivatedRetention)
+			if err != nil {
+				app.logger.Errorw("error purging unactivated users", "error", err)
+				continue
+			}
+
+			if deleted > 0 {
+				app.logger.Infow("purged unactivated users", "count", deleted)
+			}
+		}
+	}
+}
+
+// deleteExpiredSessions periodically removes the expired login sessions.
+func (app *application) deleteExpiredSessions(ctx context.Context) {
+	ticker := time.NewTicker(app.config.jobs.sessionsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := app.store.Sessions.DeleteExpired(ctx)
+			if err != nil {
+				app.logger.Errorw("error deleting expired sessions", "error", err)
+				continue
+			}
+
+			if deleted > 0 {
+				app.logger.Infow("deleted expired sessions", "count", deleted)
+			}
+		}
+	}
+}
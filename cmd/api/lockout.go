@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bruno120805/project/internal/mail"
+	"github.com/bruno120805/project/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// loginFailureWindow is how long a failed login counts
+	loginFailureWindow = time.Hour
+	// loginDelayAfter is the failures after which every new failure makes
+	// the account wait, twice as long each time
+	loginDelayAfter     = 3
+	accountLockoutAfter = 5
+	ipLockoutAfter      = 20
+	lockoutBaseDuration = 15 * time.Minute
+	lockoutMaxDuration  = 24 * time.Hour
+)
+
+// clientIP returns the IP of the request, RealIP already replaced the remote
+// address with the forwarded one.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// loginDelay is the wait after a failure before the lockout threshold.
+func loginDelay(failures int) time.Duration {
+	if failures < loginDelayAfter {
+		return 0
+	}
+
+	return time.Second << (failures - loginDelayAfter + 1)
+}
+
+// lockoutDuration doubles with every previous lockout up to the maximum.
+func lockoutDuration(lockouts int) time.Duration {
+	d := lockoutBaseDuration
+	for i := 0; i < lockouts && d < lockoutMaxDuration; i++ {
+		d *= 2
+	}
+
+	return min(d, lockoutMaxDuration)
+}
+
+// loginRetryAfter returns how long the account or the IP must wait before
+// trying to log in again, zero if they can try now.
+func (app *application) loginRetryAfter(ctx context.Context, email, ip string) (time.Duration, error) {
+	now := time.Now()
+
+	var wait time.Duration
+	for scope, key := range map[string]string{store.ThrottleAccount: email, store.ThrottleIP: ip} {
+		throttle, err := app.store.LoginThrottles.Get(ctx, scope, key)
+		if err != nil {
+			return 0, err
+		}
+
+		if throttle.IsLocked(now) {
+			wait = max(wait, throttle.LockedUntil.Sub(now))
+		}
+	}
+
+	return wait, nil
+}
+
+// recordLoginFailure counts the failure against the account and the IP and
+// locks them when they reach the threshold. user is nil when the email
+// doesn't belong to any account, which is tracked the same way.
+func (app *application) recordLoginFailure(ctx context.Context, email, ip string, user *store.User) error {
+	now := time.Now()
+
+	account, err := app.store.LoginThrottles.RecordFailure(ctx, store.ThrottleAccount, email, loginFailureWindow)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case account.Failures >= accountLockoutAfter:
+		duration := lockoutDuration(account.Lockouts)
+		if err := app.store.LoginThrottles.Block(ctx, store.ThrottleAccount, email, now.Add(duration), true); err != nil {
+			return err
+		}
+
+		if err := app.onAccountLocked(ctx, email, ip, user, duration); err != nil {
+			return err
+		}
+	case loginDelay(account.Failures) > 0:
+		until := now.Add(loginDelay(account.Failures))
+		if err := app.store.LoginThrottles.Block(ctx, store.ThrottleAccount, email, until, false); err != nil {
+			return err
+		}
+	}
+
+	addr, err := app.store.LoginThrottles.RecordFailure(ctx, store.ThrottleIP, ip, loginFailureWindow)
+	if err != nil {
+		return err
+	}
+
+	if addr.Failures >= ipLockoutAfter {
+		duration := lockoutDuration(addr.Lockouts)
+		if err := app.store.LoginThrottles.Block(ctx, store.ThrottleIP, ip, now.Add(duration), true); err != nil {
+			return err
+		}
+
+		entry, err := store.NewAuditEntry(store.AuditIPLocked, nil, nil, ip, map[string]any{
+			"duration_minutes": int(duration.Minutes()),
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := app.store.Audit.Record(ctx, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// onAccountLocked audits the lockout and lets the owner of the account know.
+func (app *application) onAccountLocked(ctx context.Context, email, ip string, user *store.User, duration time.Duration) error {
+	var userID *int64
+	if user != nil {
+		userID = &user.ID
+	}
+
+	entry, err := store.NewAuditEntry(store.AuditAccountLocked, nil, userID, ip, map[string]any{
+		"email":            email,
+		"duration_minutes": int(duration.Minutes()),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := app.store.Audit.Record(ctx, entry); err != nil {
+		return err
+	}
+
+	app.logger.Warnw("Account locked", "user", userID, "ip", ip, "duration", duration)
+
+	if user == nil {
+		return nil
+	}
+
+	vars := struct {
+		Username      string
+		LockedMinutes int
+		IP            string
+	}{
+		Username:      user.Username,
+		LockedMinutes: int(duration.Minutes()),
+		IP:            ip,
+	}
+
+	notice, err := store.NewOutboxEmail(mail.AccountLockedTemplate, user.Locale, user.Username, user.Email, vars)
+	if err != nil {
+		return err
+	}
+
+	return app.store.Outbox.Enqueue(ctx, notice)
+}
+
+// UnlockUser godoc
+//
+//	@Summary		Unlocks a user account
+//	@Description	Clears the failed logins and the lockout of the account, admins only
+//	@Tags			admin
+//	@Param			userID	path	int	true	"User ID"
+//	@Success		204
+//	@Failure		404	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/users/{userID}/unlock [post]
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	user, err := app.store.Users.GetUserByID(ctx, userID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.store.LoginThrottles.Reset(ctx, store.ThrottleAccount, user.Email); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	admin := app.getUserFromCtx(r)
+
+	entry, err := store.NewAuditEntry(store.AuditAccountUnlocked, &admin.ID, &user.ID, clientIP(r), nil)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Audit.Record(ctx, entry); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetAuditLog godoc
+//
+//	@Summary		Lists the audit log
+//	@Description	Lists the security relevant events, admins only
+//	@Tags			admin
+//	@Produce		json
+//	@Param			action	query		string	false	"Action"
+//	@Param			limit	query		int		false	"Limit"
+//	@Param			offset	query		int		false	"Offset"
+//	@Success		200		{array}		store.AuditEntry
+//	@Failure		400		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/audit [get]
+func (app *application) getAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	action := r.URL.Query().Get("action")
+	if err := Validate.Var(action, "omitempty,max=50"); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	fq := store.PaginatedFeedQuery{
+		Limit:  20,
+		Offset: 0,
+		Sort:   "desc",
+	}
+
+	fq, err := fq.Parse(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(fq); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	entries, err := app.store.Audit.List(r.Context(), action, fq)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, entries); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
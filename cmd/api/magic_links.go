@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bruno120805/project/internal/mail"
+	"github.com/bruno120805/project/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	// magicLinksPerWindow is how many login links an email gets per window
+	magicLinksPerWindow = 3
+	magicLinkWindow     = 15 * time.Minute
+)
+
+type MagicLinkPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+// RequestMagicLink godoc
+//
+//	@Summary		Emails a login link
+//	@Description	Emails a single-use link to log in without password, unknown emails get the same response
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		MagicLinkPayload	true	"Account email"
+//	@Success		202		{string}	string				"Login link sent"
+//	@Failure		400		{object}	error
+//	@Failure		429		{object}	error
+//	@Router			/auth/magic-link [post]
+func (app *application) requestMagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	var payload MagicLinkPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	email := strings.ToLower(payload.Email)
+
+	// every request counts, known or not, so the limit doesn't reveal accounts
+	throttle, err := app.store.LoginThrottles.RecordFailure(ctx, store.ThrottleMagicLink, email, magicLinkWindow)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if throttle.Failures > magicLinksPerWindow {
+		app.loginThrottledResponse(w, r, magicLinkWindow)
+		return
+	}
+
+	user, err := app.store.Users.GetUserByEmail(ctx, payload.Email)
+	switch {
+	case err == nil && user.IsActive:
+		if err := app.sendMagicLink(r, user); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+	case err == nil, err == store.ErrNotFound:
+		app.logger.Infow("magic link for unknown or not activated account", "email", payload.Email)
+	default:
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusAccepted, "Login link sent"); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) sendMagicLink(r *http.Request, user *store.User) error {
+	plainToken := uuid.New().String()
+
+	// store token in DB hashed
+	hash := sha256.Sum256([]byte(plainToken))
+	hashedToken := hex.EncodeToString(hash[:])
+
+	vars := struct {
+		Username         string
+		LoginURL         string
+		ExpiresInMinutes int
+	}{
+		Username:         user.Username,
+		LoginURL:         fmt.Sprintf("%s/magic-link/%s", app.config.frontendURL, plainToken),
+		ExpiresInMinutes: int(app.config.mail.magicLinkExp.Minutes()),
+	}
+
+	email, err := store.NewOutboxEmail(mail.MagicLinkTemplate, user.Locale, user.Username, user.Email, vars)
+	if err != nil {
+		return err
+	}
+
+	return app.store.MagicLinks.Create(r.Context(), user.ID, hashedToken, app.config.mail.magicLinkExp, email)
+}
+
+// MagicLinkLogin godoc
+//
+//	@Summary		Logs in with a login link
+//	@Description	Exchanges the token of a login link for a user token, or an MFA challenge
+//	@Tags			authentication
+//	@Produce		json
+//	@Param			token	path		string	true	"Login link token"
+//	@Success		200		{object}	UserWithToken
+//	@Failure		401		{object}	error
+//	@Router			/auth/magic-link/{token} [post]
+func (app *application) magicLinkLoginHandler(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	ctx := r.Context()
+
+	userID, err := app.store.MagicLinks.Consume(ctx, token)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.unauthorizedResponse(w, r, fmt.Errorf("invalid or expired login link"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.store.Users.GetUserByID(ctx, userID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.unauthorizedResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	// the link proves the email, like a correct password
+	if !user.MFAEnabled {
+		if err := app.store.LoginThrottles.Reset(ctx, store.ThrottleAccount, user.Email); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+	}
+
+	app.loginResponse(w, r, user)
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/bruno120805/project/internal/auth"
@@ -11,12 +15,16 @@ import (
 	"github.com/bruno120805/project/internal/mail"
 	"github.com/bruno120805/project/internal/services"
 	"github.com/bruno120805/project/internal/store"
+	"github.com/gorilla/sessions"
 	"github.com/joho/godotenv"
 	"go.uber.org/zap"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 )
 
+// minSecretLength is the shortest secret accepted to sign tokens and cookies.
+const minSecretLength = 32
+
 //	@title			Gopher
 //	@description	API for Gopher
 //	@termsOfService	http://swagger.io/terms/
@@ -47,6 +55,7 @@ func main() {
 	cfg := config{
 		addr:        env.GetString("ADDR", ":8081"),
 		apiURL:      env.GetString("API_URL", "localhost:8081"),
+		externalURL: env.GetString("EXTERNAL_URL", "http://localhost:8081"),
 		frontendURL: env.GetString("FRONTEND_URL", "http://localhost:3000"),
 		env:         env.GetString("ENV", "development"),
 		db: dbConfig{
@@ -56,19 +65,79 @@ func main() {
 			maxIdleTime:  env.GetString("DB_MAX_IDLE_TIME", "15m"),
 		},
 		mail: mailConfig{
-			fromEmail: env.GetString("FROM_EMAIL", ""),
-			exp:       time.Hour * 24 * 3, // 3 days ,
-			mailTrap: mailTrapConfig{
-				apiKey: env.GetString("MAILTRAP_API_KEY", ""),
+			exp:            time.Hour * 24 * 3, // 3 days ,
+			emailChangeExp: time.Hour * 24,
+			magicLinkExp:   time.Minute * 15,
+			client: mail.Config{
+				Provider:       env.GetString("MAIL_PROVIDER", defaultMailProvider(env.GetString("ENV", "development"))),
+				FromEmail:      env.GetString("FROM_EMAIL", ""),
+				SendGridAPIKey: env.GetString("SENDGRID_API_KEY", ""),
+				ResendAPIKey:   env.GetString("RESEND_API_KEY", ""),
+				MailtrapAPIKey: env.GetString("MAILTRAP_API_KEY", ""),
+				SMTP: mail.SMTPConfig{
+					Host:     env.GetString("SMTP_HOST", ""),
+					Port:     env.GetInt("SMTP_PORT", 587),
+					Username: env.GetString("SMTP_USERNAME", ""),
+					Password: env.GetString("SMTP_PASSWORD", ""),
+					TLS:      env.GetString("SMTP_TLS", mail.SMTPTLSStartTLS),
+				},
+				SinkDir: env.GetString("MAIL_SINK_DIR", "tmp/mail"),
+			},
+			unsubscribeSecret: env.GetString("UNSUBSCRIBE_SECRET", "example"),
+			webhooks: mailWebhooksConfig{
+				sendGridPublicKey: env.GetString("SENDGRID_WEBHOOK_PUBLIC_KEY", ""),
+				resendSecret:      env.GetString("RESEND_WEBHOOK_SECRET", ""),
 			},
 		},
 		auth: authConfig{
 			token: tokenConfig{
-				secret: env.GetString("JWT_SECRET", "example"),
+				secret: env.GetString("JWT_SECRET", ""),
 				// TODO: change time for whatever i want
 				exp: time.Hour * 24,
 				iss: "project",
 			},
+			mfa: mfaConfig{
+				issuer:   env.GetString("MFA_ISSUER", "GopherSocial"),
+				tokenExp: time.Minute * 5,
+			},
+			providers: auth.ProvidersConfig{
+				CallbackURL: env.GetString("EXTERNAL_URL", "http://localhost:8081") + "/v1/auth/%s/callback",
+				Google: auth.ProviderConfig{
+					ClientID:     env.GetString("GOOGLE_CLIENT_ID", ""),
+					ClientSecret: env.GetString("GOOGLE_CLIENT_SECRET", ""),
+					RedirectURL:  env.GetString("GOOGLE_REDIRECT_URL", ""),
+					Scopes:       env.GetStrings("GOOGLE_SCOPES", []string{"email", "profile"}),
+				},
+				GitHub: auth.ProviderConfig{
+					ClientID:     env.GetString("GITHUB_CLIENT_ID", ""),
+					ClientSecret: env.GetString("GITHUB_CLIENT_SECRET", ""),
+					RedirectURL:  env.GetString("GITHUB_REDIRECT_URL", ""),
+					Scopes:       env.GetStrings("GITHUB_SCOPES", []string{"read:user", "user:email"}),
+					// github only returns public or verified primary emails
+					TrustEmail: true,
+				},
+				Microsoft: auth.MicrosoftConfig{
+					ProviderConfig: auth.ProviderConfig{
+						ClientID:     env.GetString("MICROSOFT_CLIENT_ID", ""),
+						ClientSecret: env.GetString("MICROSOFT_CLIENT_SECRET", ""),
+						RedirectURL:  env.GetString("MICROSOFT_REDIRECT_URL", ""),
+						Scopes:       env.GetStrings("MICROSOFT_SCOPES", []string{"openid", "profile", "email", "User.Read"}),
+						TrustEmail:   env.GetBool("MICROSOFT_TRUST_EMAIL", false),
+					},
+					Tenant: env.GetString("MICROSOFT_TENANT", "organizations"),
+				},
+				OIDC: auth.OIDCConfig{
+					ProviderConfig: auth.ProviderConfig{
+						ClientID:     env.GetString("OIDC_CLIENT_ID", ""),
+						ClientSecret: env.GetString("OIDC_CLIENT_SECRET", ""),
+						RedirectURL:  env.GetString("OIDC_REDIRECT_URL", ""),
+						Scopes:       env.GetStrings("OIDC_SCOPES", []string{"openid", "email", "profile"}),
+						TrustEmail:   env.GetBool("OIDC_TRUST_EMAIL", false),
+					},
+					Name:         env.GetString("OIDC_NAME", "oidc"),
+					DiscoveryURL: env.GetString("OIDC_DISCOVERY_URL", ""),
+				},
+			},
 		},
 		uploader: uploaderConfig{
 			region: env.GetString("AWS_REGION", "us-east-1"),
@@ -77,16 +146,33 @@ func main() {
 		oauth: &oauth2.Config{
 			ClientID:     env.GetString("GOOGLE_CLIENT_ID", ""),
 			ClientSecret: env.GetString("GOOGLE_CLIENT_SECRET", ""),
-			RedirectURL:  env.GetString("GOOGLE_REDIRECT_URL", ""),
-			Scopes:       []string{"email", "profile"},
+			RedirectURL:  env.GetString("OAUTH_REDIRECT_URL", env.GetString("EXTERNAL_URL", "http://localhost:8081")+"/v1/auth/oauth/callback"),
+			Scopes:       []string{"openid", "email", "profile"},
 			Endpoint:     google.Endpoint,
 		},
+		session: sessionConfig{
+			secret:   env.GetString("SESSION_SECRET", ""),
+			maxAge:   time.Hour * 24 * time.Duration(env.GetInt("SESSION_MAX_AGE_DAYS", 30)),
+			secure:   env.GetBool("SESSION_SECURE", env.GetString("ENV", "development") == "production"),
+			httpOnly: env.GetBool("SESSION_HTTP_ONLY", true),
+			sameSite: parseSameSite(env.GetString("SESSION_SAME_SITE", "lax")),
+		},
+		jobs: jobsConfig{
+			purgeInterval:        time.Hour,
+			unactivatedRetention: time.Hour * 24 * time.Duration(env.GetInt("UNACTIVATED_RETENTION_DAYS", 7)),
+			outboxInterval:       time.Second * 5,
+			sessionsInterval:     time.Hour,
+		},
 	}
 
 	// Logger
 	logger := zap.Must(zap.NewProduction()).Sugar()
 	defer logger.Sync()
 
+	if err := validateSecrets(cfg); err != nil {
+		logger.Fatal(err)
+	}
+
 	// Database
 	db, err := db.New(
 		cfg.db.addr,
@@ -101,14 +187,24 @@ func main() {
 
 	logger.Info("Database connection established")
 
+	// Sessions
+	sessionStore := store.NewSessionStore(db, sessions.Options{
+		Path:     "/",
+		MaxAge:   int(cfg.session.maxAge.Seconds()),
+		Secure:   cfg.session.secure,
+		HttpOnly: cfg.session.httpOnly,
+		SameSite: cfg.session.sameSite,
+	})
+
 	// Store
 	store := store.NewPostgresStorage(db)
 
 	// mailer
-	mailer, err := mail.NewMailTrapClient(cfg.mail.mailTrap.apiKey, cfg.mail.fromEmail)
+	mailer, err := mail.New(cfg.mail.client)
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal(err)
 	}
+	logger.Infow("Mailer configured", "provider", cfg.mail.client.Provider)
 
 	// authenticator
 	authenticator := auth.NewJWTAuthenticator(cfg.auth.token.secret, cfg.auth.token.iss, cfg.auth.token.iss)
@@ -119,7 +215,9 @@ func main() {
 		logger.Fatal(err)
 	}
 
-	auth.NewAuth()
+	if err := auth.NewAuth(cfg.auth.providers, sessionStore); err != nil {
+		logger.Fatal(err)
+	}
 
 	app := &application{
 		config:        cfg,
@@ -128,8 +226,54 @@ func main() {
 		mailer:        mailer,
 		authenticator: authenticator,
 		uploader:      uploader,
+		unsubscribe:   mail.NewUnsubscribeSigner(cfg.mail.unsubscribeSecret),
+		oauth:         auth.NewOAuthFlow(cfg.oauth, auth.GoogleUserInfoURL, cfg.session.secret, cfg.session.secure),
 	}
 
+	go app.purgeUnactivatedUsers(context.Background())
+	go app.runOutboxDispatcher(context.Background())
+	go app.deleteExpiredSessions(context.Background())
+
 	mux := app.mount()
 	logger.Fatal(app.run(mux))
 }
+
+// validateSecrets makes the server fail at boot instead of on every login
+// when a signing secret is missing or too short.
+func validateSecrets(cfg config) error {
+	secrets := []struct {
+		name  string
+		value string
+	}{
+		{"JWT_SECRET", cfg.auth.token.secret},
+		{"SESSION_SECRET", cfg.session.secret},
+	}
+
+	for _, s := range secrets {
+		if len(s.value) < minSecretLength {
+			return fmt.Errorf("%s must be at least %d bytes", s.name, minSecretLength)
+		}
+	}
+
+	return nil
+}
+
+// parseSameSite maps the SESSION_SAME_SITE values, unknown values use lax.
+func parseSameSite(mode string) http.SameSite {
+	switch strings.ToLower(mode) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+// defaultMailProvider keeps development from sending real emails.
+func defaultMailProvider(env string) string {
+	if env == "production" {
+		return mail.ProviderMailtrap
+	}
+	return mail.ProviderSink
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bruno120805/project/internal/auth"
+	"github.com/bruno120805/project/internal/store"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mfaTokenType marks the short-lived tokens issued between the password and
+// the second factor, they are rejected everywhere but the MFA login.
+const mfaTokenType = "mfa"
+
+type MFALoginPayload struct {
+	MFAToken     string `json:"mfa_token" validate:"required"`
+	Code         string `json:"code" validate:"required_without=RecoveryCode,omitempty,numeric,len=6"`
+	RecoveryCode string `json:"recovery_code" validate:"omitempty,max=20"`
+}
+
+type MFACodePayload struct {
+	Code string `json:"code" validate:"required,numeric,len=6"`
+}
+
+type DisableMFAPayload struct {
+	Password     string `json:"password" validate:"required,max=72"`
+	Code         string `json:"code" validate:"required_without=RecoveryCode,omitempty,numeric,len=6"`
+	RecoveryCode string `json:"recovery_code" validate:"omitempty,max=20"`
+}
+
+type MFASetup struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// generateMFAToken issues the challenge token a user with MFA gets after the
+// password check, it is exchanged for a user token with a valid code.
+func (app *application) generateMFAToken(user *store.User) (string, error) {
+	claims := jwt.MapClaims{
+		"sub": user.ID,
+		"ver": user.TokenVersion,
+		"typ": mfaTokenType,
+		"exp": time.Now().Add(app.config.auth.mfa.tokenExp).Unix(),
+		"iat": time.Now().Unix(),
+		"nbf": time.Now().Unix(),
+		"iss": app.config.auth.token.iss,
+		"aud": app.config.auth.token.iss,
+	}
+
+	return app.authenticator.GenerateToken(claims)
+}
+
+// tokenType returns the typ claim, user tokens have none.
+func tokenType(claims jwt.MapClaims) string {
+	typ, _ := claims["typ"].(string)
+	return typ
+}
+
+// verifySecondFactor checks a TOTP code, or a recovery code when given, and
+// consumes it so it can't be used again.
+func (app *application) verifySecondFactor(ctx context.Context, userID int64, code, recoveryCode string) error {
+	if recoveryCode != "" {
+		return app.store.MFA.UseRecoveryCode(ctx, userID, auth.HashRecoveryCode(recoveryCode))
+	}
+
+	settings, err := app.store.MFA.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if !settings.Enabled {
+		return store.ErrMFANotEnabled
+	}
+
+	step, ok := auth.ValidateTOTP(settings.Secret, code, time.Now())
+	if !ok {
+		return store.ErrInvalidMFACode
+	}
+
+	return app.store.MFA.UseStep(ctx, userID, step)
+}
+
+// roleRequiresMFA tells if the role is at or above admin, including the
+// roles given by a school membership.
+func (app *application) roleRequiresMFA(ctx context.Context, role *store.Role) (bool, error) {
+	admin, err := app.store.Roles.GetRoleByName(ctx, "admin")
+	if err != nil {
+		return false, err
+	}
+
+	return role.Level >= admin.Level, nil
+}
+
+// LoginMFA godoc
+//
+//	@Summary		Completes a login with the second factor
+//	@Description	Exchanges the MFA token of the login and a TOTP or recovery code for a user token
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		MFALoginPayload	true	"MFA token and code"
+//	@Success		200		{string}	string			"JWT token for authentication"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Router			/auth/login/mfa [post]
+func (app *application) loginMFAHandler(w http.ResponseWriter, r *http.Request) {
+	var payload MFALoginPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	jwtToken, err := app.authenticator.ValidateToken(payload.MFAToken)
+	if err != nil {
+		app.unauthorizedResponse(w, r, err)
+		return
+	}
+
+	claims := jwtToken.Claims.(jwt.MapClaims)
+	if tokenType(claims) != mfaTokenType {
+		app.unauthorizedResponse(w, r, errors.New("not an MFA token"))
+		return
+	}
+
+	userID, err := strconv.ParseInt(fmt.Sprintf("%.f", claims["sub"]), 10, 64)
+	if err != nil {
+		app.unauthorizedResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	user, err := app.store.Users.GetUserByID(ctx, userID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.unauthorizedResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if tokenVersion(claims) != user.TokenVersion {
+		app.unauthorizedResponse(w, r, fmt.Errorf("token has been revoked"))
+		return
+	}
+
+	// wrong codes count as failed logins of the account
+	ip := clientIP(r)
+
+	wait, err := app.loginRetryAfter(ctx, user.Email, ip)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if wait > 0 {
+		app.loginThrottledResponse(w, r, wait)
+		return
+	}
+
+	if err := app.verifySecondFactor(ctx, user.ID, payload.Code, payload.RecoveryCode); err != nil {
+		switch err {
+		case store.ErrInvalidMFACode, store.ErrMFANotEnabled:
+			if err := app.recordLoginFailure(ctx, user.Email, ip, user); err != nil {
+				app.internalServerError(w, r, err)
+				return
+			}
+			app.unauthorizedResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.store.LoginThrottles.Reset(ctx, store.ThrottleAccount, user.Email); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// the user may have been suspended since the first factor
+	if !app.checkSuspension(w, r, user) {
+		return
+	}
+
+	token, err := app.generateUserToken(user)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.startSession(w, r, user); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"token": token,
+		"user":  user,
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, resp); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// SetupMFA godoc
+//
+//	@Summary		Starts the two-factor enrollment
+//	@Description	Generates a TOTP secret and its otpauth URI to show as a QR code
+//	@Tags			users
+//	@Produce		json
+//	@Success		200	{object}	MFASetup
+//	@Failure		409	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/mfa/setup [post]
+func (app *application) setupMFAHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.getUserFromCtx(r)
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.MFA.SetPendingSecret(r.Context(), user.ID, secret); err != nil {
+		switch err {
+		case store.ErrMFAAlreadyEnabled:
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	setup := &MFASetup{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(app.config.auth.mfa.issuer, user.Email, secret),
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, setup); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// EnableMFA godoc
+//
+//	@Summary		Enables two-factor authentication
+//	@Description	Confirms the enrollment with a code of the authenticator app, the recovery codes are only shown once
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		MFACodePayload	true	"TOTP code"
+//	@Success		200		{object}	RecoveryCodes
+//	@Failure		400		{object}	error
+//	@Failure		409		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/mfa/enable [post]
+func (app *application) enableMFAHandler(w http.ResponseWriter, r *http.Request) {
+	var payload MFACodePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.getUserFromCtx(r)
+	ctx := r.Context()
+
+	settings, err := app.store.MFA.Get(ctx, user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if settings.Enabled {
+		app.conflictResponse(w, r, store.ErrMFAAlreadyEnabled)
+		return
+	}
+
+	if settings.Secret == "" {
+		app.badRequestResponse(w, r, errors.New("two-factor setup has not been started"))
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(settings.Secret, payload.Code, time.Now())
+	if !ok {
+		app.badRequestResponse(w, r, store.ErrInvalidMFACode)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.MFA.Enable(ctx, user.ID, step, hashes); err != nil {
+		switch err {
+		case store.ErrMFAAlreadyEnabled:
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, &RecoveryCodes{RecoveryCodes: codes}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// DisableMFA godoc
+//
+//	@Summary		Disables two-factor authentication
+//	@Description	Requires the password and a TOTP or recovery code
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		DisableMFAPayload	true	"Password and code"
+//	@Success		204		{string}	string				"MFA disabled"
+//	@Failure		400		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/mfa/disable [post]
+func (app *application) disableMFAHandler(w http.ResponseWriter, r *http.Request) {
+	var payload DisableMFAPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.getUserFromCtx(r)
+
+	if err := user.Password.Compare(payload.Password); err != nil {
+		app.badRequestResponse(w, r, errors.New("current password is incorrect"))
+		return
+	}
+
+	ctx := r.Context()
+
+	if err := app.verifySecondFactor(ctx, user.ID, payload.Code, payload.RecoveryCode); err != nil {
+		switch err {
+		case store.ErrInvalidMFACode, store.ErrMFANotEnabled:
+			app.badRequestResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.store.MFA.Disable(ctx, user.ID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes godoc
+//
+//	@Summary		Regenerates the recovery codes
+//	@Description	Replaces every recovery code, the new ones are only shown once
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		MFACodePayload	true	"TOTP code"
+//	@Success		200		{object}	RecoveryCodes
+//	@Failure		400		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/mfa/recovery-codes [post]
+func (app *application) regenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	var payload MFACodePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.getUserFromCtx(r)
+	ctx := r.Context()
+
+	if err := app.verifySecondFactor(ctx, user.ID, payload.Code, ""); err != nil {
+		switch err {
+		case store.ErrInvalidMFACode, store.ErrMFANotEnabled:
+			app.badRequestResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.MFA.ReplaceRecoveryCodes(ctx, user.ID, hashes); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, &RecoveryCodes{RecoveryCodes: codes}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashRecoveryCode(code)
+	}
+
+	return codes, hashes, nil
+}
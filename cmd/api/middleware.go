@@ -24,7 +24,10 @@ var (
 
 type contextKey string
 
-const userKey contextKey = "user"
+const (
+	userKey   contextKey = "user"
+	apiKeyKey contextKey = "apiKey"
+)
 
 func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -38,6 +41,11 @@ func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
 		if authHeader != "" {
 			// Si el header está presente, valida el JWT
 			parts := strings.Split(authHeader, " ")
+			if len(parts) == 2 && parts[0] == "ApiKey" {
+				app.authenticateAPIKey(w, r, next, parts[1])
+				return
+			}
+
 			if len(parts) != 2 || parts[0] != "Bearer" {
 				app.unauthorizedResponse(w, r, fmt.Errorf("unauthorized"))
 				return
@@ -53,6 +61,11 @@ func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
 			}
 
 			claims := jwtToken.Claims.(jwt.MapClaims)
+			if tokenType(claims) != "" {
+				app.unauthorizedResponse(w, r, fmt.Errorf("not a user token"))
+				return
+			}
+
 			userID, err := strconv.ParseInt(fmt.Sprintf("%.f", claims["sub"]), 10, 64)
 			if err != nil {
 				app.unauthorizedResponse(w, r, err)
@@ -67,6 +80,15 @@ func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
 				return
 			}
 
+			if tokenVersion(claims) != user.TokenVersion {
+				app.unauthorizedResponse(w, r, fmt.Errorf("token has been revoked"))
+				return
+			}
+
+			if !app.checkSuspension(w, r, user) {
+				return
+			}
+
 			// Agrega el usuario al contexto
 			ctx = context.WithValue(ctx, userKey, user)
 			next.ServeHTTP(w, r.WithContext(ctx))
@@ -75,28 +97,6 @@ func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) checkPostOwnership(requiredRole string, next http.HandlerFunc) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		user := app.getUserFromCtx(r)
-
-		ctx := r.Context()
-
-		// Check user Role
-		role, err := app.store.Roles.GetRoleByName(ctx, requiredRole)
-		if err != nil {
-			app.internalServerError(w, r, err)
-			return
-		}
-
-		if user.Role.Level < role.Level {
-			app.forbiddenResponse(w, r, fmt.Errorf("forbidden"))
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
-}
-
 func getVisitor(ip string) *rate.Limiter {
 	mu.Lock()
 	defer mu.Unlock()
@@ -1,17 +1,33 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bruno120805/project/internal/store"
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 )
 
+const archiveWriteTimeout = 5 * time.Minute
+
+type NoteWithWarnings struct {
+	*store.Note
+	Warnings []string `json:"warnings,omitempty"`
+}
+
 type CreateNotePayload struct {
 	Content     string   `json:"content" validate:"required"`
 	Subject     string   `json:"subject" validate:"required"`
@@ -93,7 +109,29 @@ func (app *application) createNoteHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	usage, err := app.store.Quotas.GetUsage(ctx, user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if usage.BytesUsed+totalSize > usage.QuotaBytes {
+		app.quotaExceededResponse(w, r, store.ErrQuotaExceeded)
+		return
+	}
+
 	var fileURLs []string
+	var fileHashes []string
+	var noteFiles []*store.NoteFile
+
+	// the blobs are referenced as the files are stored, the references are
+	// given back if the note is not created
+	created := false
+	defer func() {
+		if !created {
+			app.releaseNoteBlobs(context.WithoutCancel(ctx), fileHashes)
+		}
+	}()
 
 	for _, handler := range files {
 
@@ -112,46 +150,110 @@ func (app *application) createNoteHandler(w http.ResponseWriter, r *http.Request
 			return
 		}
 
-		file, err := handler.Open()
+		data, err := readMultipartFile(handler)
 		if err != nil {
 			app.badRequestResponse(w, r, err)
 			return
 		}
-		defer file.Close()
 
-		key := handler.Filename
+		// files are stored by content so the same file is only uploaded once
+		hash := sha256.Sum256(data)
+		fileHash := hex.EncodeToString(hash[:])
+
+		// new objects get a name of their own, so they never collide with the
+		// object of the same content a concurrent delete is removing
+		key := uuid.New().String() + strings.ToLower(filepath.Ext(handler.Filename))
 
-		err = app.uploader.UploadFile(app.config.uploader.bucket, key, file)
+		key, err = app.store.Notes.AcquireBlob(ctx, fileHash, key, handler.Size, func(key string) error {
+			return app.uploader.UploadFile(app.config.uploader.bucket, key, bytes.NewReader(data))
+		})
 		if err != nil {
-			app.badRequestResponse(w, r, err)
+			app.internalServerError(w, r, err)
 			return
 		}
+		fileHashes = append(fileHashes, fileHash)
 
 		// save URL to database
 
 		fileURL := fmt.Sprintf("https://%s.s3.amazonaws.com/%s", app.config.uploader.bucket, key)
 		fileURLs = append(fileURLs, fileURL)
 
+		noteFiles = append(noteFiles, &store.NoteFile{
+			Key:       key,
+			FileName:  handler.Filename,
+			SizeBytes: handler.Size,
+			SHA256:    fileHash,
+		})
+	}
+
+	// warn the uploader when the professor already has the same files
+	duplicates, err := app.store.Notes.FindDuplicates(ctx, professorID, fileHashes)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
 	}
+
 	note := &store.Note{
 		Content:     payload.Content,
 		Subject:     payload.Subject,
 		Title:       payload.Title,
 		FilesURL:    fileURLs,
+		Files:       noteFiles,
 		ProfessorID: professorID,
 	}
 
 	err = app.store.Notes.Create(ctx, user.ID, note)
 	if err != nil {
-		app.internalServerError(w, r, err)
+		switch err {
+		case store.ErrQuotaExceeded:
+			app.quotaExceededResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
 		return
 	}
+	created = true
 
-	if err := app.jsonResponse(w, http.StatusCreated, note); err != nil {
+	resp := &NoteWithWarnings{Note: note}
+	for _, d := range duplicates {
+		resp.Warnings = append(resp.Warnings, fmt.Sprintf("el archivo %s ya fue subido en la nota \"%s\" de este profesor", d.FileName, d.NoteTitle))
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, resp); err != nil {
 		app.internalServerError(w, r, err)
 	}
 }
 
+// releaseNoteBlobs gives back the blobs taken for a note that was not created
+// and deletes the uploaded objects no other note uses.
+func (app *application) releaseNoteBlobs(ctx context.Context, hashes []string) {
+	if len(hashes) == 0 {
+		return
+	}
+
+	keys, err := app.store.Notes.ReleaseBlobs(ctx, hashes)
+	if err != nil {
+		app.logger.Errorw("error releasing note files", "error", err)
+		return
+	}
+
+	for _, key := range keys {
+		if err := app.uploader.DeleteFile(app.config.uploader.bucket, key); err != nil {
+			app.logger.Errorw("error deleting note file", "key", key, "error", err)
+		}
+	}
+}
+
+func readMultipartFile(handler *multipart.FileHeader) ([]byte, error) {
+	file, err := handler.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
+
 func isValidExtension(fileName string) bool {
 	allowedExtensions := map[string]bool{
 		"jpg":  true,
@@ -208,6 +310,33 @@ func (app *application) getNoteByNameHandler(w http.ResponseWriter, r *http.Requ
 	}
 }
 
+type PaginatedNotes struct {
+	Notes   []*store.Note `json:"notes"`
+	Total   int           `json:"total"`
+	Limit   int           `json:"limit"`
+	Offset  int           `json:"offset"`
+	HasMore bool          `json:"has_more"`
+}
+
+// GetNotes godoc
+//
+//	@Summary		Fetches the notes of a professor
+//	@Description	Fetches the notes of a professor paginated and filtered
+//	@Tags			notes
+//	@Produce		json
+//	@Param			professorID	path		int		true	"Professor ID"
+//	@Param			limit		query		int		false	"Limit"
+//	@Param			offset		query		int		false	"Offset"
+//	@Param			sort		query		string	false	"Sort by creation date (asc, desc)"
+//	@Param			search		query		string	false	"Title search"
+//	@Param			since		query		string	false	"Created since (YYYY-MM-DD HH:MM:SS)"
+//	@Param			until		query		string	false	"Created until (YYYY-MM-DD HH:MM:SS)"
+//	@Param			subject		query		string	false	"Subject"
+//	@Param			author		query		int		false	"Author user ID"
+//	@Success		200			{object}	PaginatedNotes
+//	@Failure		400			{object}	error
+//	@Failure		500			{object}	error
+//	@Router			/notes/{professorID} [get]
 func (app *application) getNotesHandler(w http.ResponseWriter, r *http.Request) {
 	professorID, err := strconv.ParseInt(chi.URLParam(r, "professorID"), 10, 64)
 	if err != nil {
@@ -215,15 +344,53 @@ func (app *application) getNotesHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	fq := store.PaginatedFeedQuery{
+		Limit:  10,
+		Offset: 0,
+		Sort:   "desc",
+		Search: "",
+	}
+
+	fq, err = fq.Parse(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(fq); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	filter := store.NoteFilter{
+		Subject: r.URL.Query().Get("subject"),
+	}
+
+	if author := r.URL.Query().Get("author"); author != "" {
+		filter.AuthorID, err = strconv.ParseInt(author, 10, 64)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+
 	ctx := r.Context()
 
-	notes, err := app.store.Notes.GetNotes(ctx, professorID)
+	notes, total, err := app.store.Notes.GetNotes(ctx, professorID, fq, filter)
 	if err != nil {
-		app.notFoundResponse(w, r, err)
+		app.internalServerError(w, r, err)
 		return
 	}
 
-	if err := app.jsonResponse(w, http.StatusOK, notes); err != nil {
+	resp := PaginatedNotes{
+		Notes:   notes,
+		Total:   total,
+		Limit:   fq.Limit,
+		Offset:  fq.Offset,
+		HasMore: fq.Offset+len(notes) < total,
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, resp); err != nil {
 		app.internalServerError(w, r, err)
 	}
 }
@@ -257,7 +424,7 @@ func (app *application) deleteNoteHandler(w http.ResponseWriter, r *http.Request
 
 	ctx := r.Context()
 
-	note, err := app.store.Notes.GetNoteByID(ctx, noteID)
+	note, err := app.store.Notes.GetNoteByIDWithHidden(ctx, noteID)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
@@ -265,22 +432,160 @@ func (app *application) deleteNoteHandler(w http.ResponseWriter, r *http.Request
 
 	user := app.getUserFromCtx(r)
 
+	// the author or a moderator can delete a note
 	if note.UserID != user.ID {
-		app.unauthorizedResponse(w, r, fmt.Errorf("unauthorized"))
+		allowed, err := app.hasPermission(ctx, user, store.PermissionNoteDeleteAny)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		if !allowed {
+			app.forbiddenResponse(w, r, fmt.Errorf("forbidden"))
+			return
+		}
+	}
+
+	files, err := app.store.Notes.GetFiles(ctx, noteID)
+	if err != nil {
+		app.internalServerError(w, r, err)
 		return
 	}
 
-	if err := app.store.Notes.Delete(ctx, noteID); err != nil {
+	orphanKeys, err := app.store.Notes.Delete(ctx, noteID)
+	if err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
 
+	// notes uploaded before the files were tracked own their objects, the rest
+	// only delete the objects no other note references
+	keys := orphanKeys
+	if len(files) == 0 {
+		for _, fileURL := range note.FilesURL {
+			keys = append(keys, filepath.Base(fileURL))
+		}
+	}
+
 	// delete files from s3
-	for _, fileURL := range note.FilesURL {
-		key := filepath.Base(fileURL)
+	for _, key := range keys {
 		if err := app.uploader.DeleteFile(app.config.uploader.bucket, key); err != nil {
 			app.internalServerError(w, r, err)
 			return
 		}
 	}
 }
+
+// GetNoteArchive godoc
+//
+//	@Summary		Downloads the files of a note
+//	@Description	Streams a ZIP archive with all the files of a note
+//	@Tags			notes
+//	@Produce		application/zip
+//	@Param			noteID	path		int	true	"Note ID"
+//	@Success		200		{file}		file
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/notes/{noteID}/archive [get]
+func (app *application) getNoteArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	noteID, err := strconv.ParseInt(chi.URLParam(r, "noteID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	note, err := app.store.Notes.GetNoteByID(ctx, noteID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	files, err := app.store.Notes.GetFiles(ctx, noteID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// notes uploaded before the files were tracked only have their URLs
+	if len(files) == 0 {
+		for _, fileURL := range note.FilesURL {
+			key := filepath.Base(fileURL)
+			files = append(files, &store.NoteFile{Key: key, FileName: key})
+		}
+	}
+
+	if len(files) == 0 {
+		app.notFoundResponse(w, r, errors.New("note has no files"))
+		return
+	}
+
+	// big archives can take longer than the server write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Now().Add(archiveWriteTimeout)); err != nil {
+		app.logger.Warnw("could not extend write deadline", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="note-%d.zip"`, note.ID))
+	w.WriteHeader(http.StatusOK)
+
+	// the headers are already sent, from here errors can only be logged
+	zw := zip.NewWriter(w)
+	names := make(map[string]int)
+
+	for _, f := range files {
+		if err := app.writeArchiveFile(zw, f, uniqueArchiveName(names, f.FileName)); err != nil {
+			app.logger.Errorw("error writing note archive", "noteID", note.ID, "file", f.Key, "error", err)
+			return
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		app.logger.Errorw("error closing note archive", "noteID", note.ID, "error", err)
+	}
+}
+
+func (app *application) writeArchiveFile(zw *zip.Writer, f *store.NoteFile, name string) error {
+	body, err := app.uploader.GetFile(app.config.uploader.bucket, f.Key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	// pdf and images are already compressed
+	fw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(fw, body)
+	return err
+}
+
+// uniqueArchiveName avoids two entries with the same name inside the archive,
+// "notes.pdf" becomes "notes (1).pdf".
+func uniqueArchiveName(names map[string]int, fileName string) string {
+	name := filepath.Base(fileName)
+	count := names[name]
+	names[name]++
+
+	if count == 0 {
+		return name
+	}
+
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), count, ext)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bruno120805/project/internal/mail"
+	"github.com/bruno120805/project/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type UpdateNotificationPreferencesPayload struct {
+	// Preferences maps a category to whether its emails are sent
+	Preferences map[string]bool `json:"preferences" validate:"required,min=1"`
+}
+
+// GetNotificationPreferences godoc
+//
+//	@Summary		Fetches the notification preferences
+//	@Description	Fetches the email notification preferences of the current user
+//	@Tags			notifications
+//	@Produce		json
+//	@Success		200	{array}		store.NotificationPreference
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/notifications [get]
+func (app *application) getNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.getUserFromCtx(r)
+
+	prefs, err := app.store.Notifications.GetPreferences(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, prefs); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// UpdateNotificationPreferences godoc
+//
+//	@Summary		Updates the notification preferences
+//	@Description	Enables or disables the email notifications by category
+//	@Tags			notifications
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		UpdateNotificationPreferencesPayload	true	"Preferences"
+//	@Success		200		{array}		store.NotificationPreference
+//	@Failure		400		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/notifications [patch]
+func (app *application) updateNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	var payload UpdateNotificationPreferencesPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.getUserFromCtx(r)
+	ctx := r.Context()
+
+	if err := app.store.Notifications.SetPreferences(ctx, user.ID, payload.Preferences); err != nil {
+		switch err {
+		case store.ErrUnknownCategory:
+			app.badRequestResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	prefs, err := app.store.Notifications.GetPreferences(ctx, user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, prefs); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// Unsubscribe godoc
+//
+//	@Summary		Unsubscribes from a notification category
+//	@Description	One-click unsubscribe (RFC 8058) with the signed token of the email
+//	@Tags			notifications
+//	@Produce		json
+//	@Param			token	path		string	true	"Unsubscribe token"
+//	@Success		200		{string}	string	"Unsubscribed"
+//	@Failure		400		{object}	error
+//	@Router			/notifications/unsubscribe/{token} [post]
+func (app *application) unsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	userID, category, err := app.unsubscribe.Parse(chi.URLParam(r, "token"))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := app.store.Notifications.SetPreferences(r.Context(), userID, map[string]bool{category: false}); err != nil {
+		switch err {
+		case store.ErrUnknownCategory:
+			app.badRequestResponse(w, r, mail.ErrInvalidUnsubscribeToken)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, "Unsubscribed"); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
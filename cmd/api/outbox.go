@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bruno120805/project/internal/mail"
+	"github.com/bruno120805/project/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	outboxBatchSize   = 10
+	outboxMaxAttempts = 8
+	outboxBaseDelay   = 30 * time.Second
+	outboxMaxDelay    = time.Hour
+	// outboxLease is how long a claimed email stays locked to a dispatcher
+	outboxLease = 5 * time.Minute
+)
+
+// runOutboxDispatcher delivers the queued emails until the context is done.
+func (app *application) runOutboxDispatcher(ctx context.Context) {
+	ticker := time.NewTicker(app.config.jobs.outboxInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.dispatchOutbox(ctx)
+		}
+	}
+}
+
+func (app *application) dispatchOutbox(ctx context.Context) {
+	emails, err := app.store.Outbox.Claim(ctx, outboxBatchSize, outboxLease)
+	if err != nil {
+		app.logger.Errorw("error claiming outbox emails", "error", err)
+		return
+	}
+
+	isProdEnv := app.config.env == "production"
+
+	for _, e := range emails {
+		reason, err := app.skipReason(ctx, e)
+		if err == nil && reason != "" {
+			if err := app.store.Outbox.MarkSkipped(ctx, e.ID, reason); err != nil {
+				app.logger.Errorw("error updating outbox email", "id", e.ID, "error", err)
+			}
+			continue
+		}
+
+		if err == nil {
+			err = app.sendOutboxEmail(e, !isProdEnv)
+		}
+
+		if err != nil {
+			app.logger.Errorw("error sending outbox email", "id", e.ID, "attempts", e.Attempts, "error", err)
+
+			var nextAttempt *time.Time
+			if e.Attempts < outboxMaxAttempts {
+				next := time.Now().Add(outboxBackoff(e.Attempts))
+				nextAttempt = &next
+			}
+
+			if err := app.store.Outbox.MarkFailed(ctx, e.ID, err.Error(), nextAttempt); err != nil {
+				app.logger.Errorw("error updating outbox email", "id", e.ID, "error", err)
+			}
+			continue
+		}
+
+		if err := app.store.Outbox.MarkSent(ctx, e.ID); err != nil {
+			app.logger.Errorw("error updating outbox email", "id", e.ID, "error", err)
+		}
+	}
+}
+
+// skipReason tells why the email must not be sent, if at all. It is checked
+// when the email is sent so suppressions and preferences changed after the
+// email was queued are honored.
+func (app *application) skipReason(ctx context.Context, e *store.OutboxEmail) (string, error) {
+	suppressed, err := app.store.Suppressions.IsSuppressed(ctx, e.RecipientEmail)
+	if err != nil {
+		return "", err
+	}
+
+	if suppressed {
+		return "recipient address is suppressed", nil
+	}
+
+	if !isNotification(e) {
+		return "", nil
+	}
+
+	enabled, err := app.store.Notifications.IsEnabled(ctx, *e.UserID, e.Category)
+	if err != nil {
+		return "", err
+	}
+
+	if !enabled {
+		return "notification disabled by the user", nil
+	}
+
+	return "", nil
+}
+
+func isNotification(e *store.OutboxEmail) bool {
+	return e.Category != "" && e.UserID != nil
+}
+
+func (app *application) sendOutboxEmail(e *store.OutboxEmail, isSandbox bool) error {
+	var data map[string]any
+	if err := json.Unmarshal(e.Data, &data); err != nil {
+		return err
+	}
+
+	if data == nil {
+		data = map[string]any{}
+	}
+
+	// notifications link to a page of the frontend, and to the API for the
+	// one-click unsubscribe of the mail clients
+	var unsubscribeURL string
+	if isNotification(e) {
+		token := app.unsubscribe.Token(*e.UserID, e.Category)
+		data["UnsubscribeURL"] = fmt.Sprintf("%s/unsubscribe/%s", app.config.frontendURL, token)
+		unsubscribeURL = fmt.Sprintf("%s/v1/notifications/unsubscribe/%s", app.config.externalURL, token)
+	}
+
+	msg, err := mail.Render(e.Locale, e.Template, data)
+	if err != nil {
+		return err
+	}
+	msg.To = []mail.Address{{Name: e.RecipientName, Email: e.RecipientEmail}}
+	msg.UnsubscribeURL = unsubscribeURL
+
+	status, err := app.mailer.Send(msg, isSandbox)
+	if err != nil {
+		return err
+	}
+
+	app.logger.Infow("Email sent", "id", e.ID, "status code", status)
+
+	return nil
+}
+
+// outboxBackoff doubles the delay on every attempt up to outboxMaxDelay.
+func outboxBackoff(attempts int) time.Duration {
+	delay := outboxBaseDelay
+	for i := 1; i < attempts && delay < outboxMaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, outboxMaxDelay)
+}
+
+// GetOutbox godoc
+//
+//	@Summary		Lists the queued emails
+//	@Description	Lists the emails of the outbox filtered by status, admins only
+//	@Tags			admin
+//	@Produce		json
+//	@Param			status	query		string	false	"pending, sending, sent, dead or skipped"
+//	@Param			limit	query		int		false	"Limit"
+//	@Param			offset	query		int		false	"Offset"
+//	@Success		200		{array}		store.OutboxEmail
+//	@Failure		400		{object}	error
+//	@Failure		403		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/outbox [get]
+func (app *application) getOutboxHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if err := Validate.Var(status, "omitempty,oneof=pending sending sent dead skipped"); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	fq := store.PaginatedFeedQuery{
+		Limit:  20,
+		Offset: 0,
+		Sort:   "desc",
+	}
+
+	fq, err := fq.Parse(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(fq); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	emails, err := app.store.Outbox.List(ctx, status, fq)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, emails); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// RetryOutboxEmail godoc
+//
+//	@Summary		Retries a dead lettered email
+//	@Description	Puts a dead lettered email back in the queue, admins only
+//	@Tags			admin
+//	@Produce		json
+//	@Param			emailID	path		int		true	"Outbox email ID"
+//	@Success		202		{string}	string	"Email queued"
+//	@Failure		404		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/outbox/{emailID}/retry [post]
+func (app *application) retryOutboxEmailHandler(w http.ResponseWriter, r *http.Request) {
+	emailID, err := strconv.ParseInt(chi.URLParam(r, "emailID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	if err := app.store.Outbox.Retry(ctx, emailID); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusAccepted, "Email queued"); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
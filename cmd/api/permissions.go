@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/bruno120805/project/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type SetRolePayload struct {
+	Role string `json:"role" validate:"required,max=255"`
+}
+
+// hasPermission reports whether the role of the user grants the permission,
+// and the API key of the request has it among its scopes.
+func (app *application) hasPermission(ctx context.Context, user *store.User, permission string) (bool, error) {
+	if key := apiKeyFromContext(ctx); key != nil && !key.HasScope(permission) {
+		return false, nil
+	}
+
+	role, err := app.store.Roles.GetRoleByID(ctx, user.Role.ID)
+	if err != nil {
+		return false, err
+	}
+
+	return role.HasPermission(permission), nil
+}
+
+// requirePermission only lets through users whose role grants the
+// permission, it must run after AuthTokenMiddleware.
+func (app *application) requirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := app.getUserFromCtx(r)
+			ctx := r.Context()
+
+			allowed, err := app.hasPermission(ctx, user, permission)
+			if err != nil {
+				app.internalServerError(w, r, err)
+				return
+			}
+
+			if !allowed {
+				app.forbiddenResponse(w, r, fmt.Errorf("missing permission %s", permission))
+				return
+			}
+
+			if !app.checkRoleMFA(w, r, user, &user.Role) {
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireSchoolPermission lets through users whose role grants the
+// permission, or whose membership of the school in the schoolID route param
+// does. It must run after AuthTokenMiddleware.
+func (app *application) requireSchoolPermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			schoolID, err := strconv.ParseInt(chi.URLParam(r, "schoolID"), 10, 64)
+			if err != nil {
+				app.badRequestResponse(w, r, err)
+				return
+			}
+
+			user := app.getUserFromCtx(r)
+			ctx := r.Context()
+
+			if key := apiKeyFromContext(ctx); key != nil && !key.HasScope(permission) {
+				app.forbiddenResponse(w, r, fmt.Errorf("the API key is missing the scope %s", permission))
+				return
+			}
+
+			role, err := app.store.Roles.GetRoleByID(ctx, user.Role.ID)
+			if err != nil {
+				app.internalServerError(w, r, err)
+				return
+			}
+
+			// without the permission everywhere, look at the school role
+			if !role.HasPermission(permission) {
+				roleID, err := app.store.SchoolMemberships.GetRoleID(ctx, user.ID, schoolID)
+				switch err {
+				case nil:
+				case store.ErrNotFound:
+					app.forbiddenResponse(w, r, fmt.Errorf("missing permission %s", permission))
+					return
+				default:
+					app.internalServerError(w, r, err)
+					return
+				}
+
+				role, err = app.store.Roles.GetRoleByID(ctx, roleID)
+				if err != nil {
+					app.internalServerError(w, r, err)
+					return
+				}
+
+				if !role.HasPermission(permission) {
+					app.forbiddenResponse(w, r, fmt.Errorf("missing permission %s", permission))
+					return
+				}
+			}
+
+			if !app.checkRoleMFA(w, r, user, role) {
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// checkRoleMFA makes sure privileged roles are only used once the user
+// enrolled in MFA, it writes the error response otherwise.
+func (app *application) checkRoleMFA(w http.ResponseWriter, r *http.Request, user *store.User, role *store.Role) bool {
+	required, err := app.roleRequiresMFA(r.Context(), role)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return false
+	}
+
+	if required && !user.MFAEnabled {
+		app.mfaRequiredResponse(w, r, store.ErrMFANotEnabled)
+		return false
+	}
+
+	return true
+}
+
+// GetRoles godoc
+//
+//	@Summary		Fetches the roles
+//	@Description	Fetches the roles with their permissions
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{array}		store.Role
+//	@Failure		403	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/roles [get]
+func (app *application) getRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.store.Roles.List(r.Context())
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, roles); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// SetUserRole godoc
+//
+//	@Summary		Assigns a role
+//	@Description	Changes the role of a user below the admin, only to a role below the one of the admin
+//	@Tags			admin
+//	@Accept			json
+//	@Param			userID	path	int				true	"User ID"
+//	@Param			payload	body	SetRolePayload	true	"Role name"
+//	@Success		204
+//	@Failure		400	{object}	error
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/users/{userID}/role [put]
+func (app *application) setUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var payload SetRolePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	admin := app.getUserFromCtx(r)
+
+	// nobody can lock themselves out of the admin endpoints
+	if admin.ID == userID {
+		app.badRequestResponse(w, r, errors.New("you can't change your own role"))
+		return
+	}
+
+	ctx := r.Context()
+
+	role, err := app.store.Roles.GetRoleByName(ctx, payload.Role)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.badRequestResponse(w, r, fmt.Errorf("unknown role %s", payload.Role))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	// admins only manage the users and roles below their own level
+	adminRole, err := app.store.Roles.GetRoleByID(ctx, admin.Role.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if role.Level >= adminRole.Level {
+		app.forbiddenResponse(w, r, fmt.Errorf("you can't assign the role %s", role.Name))
+		return
+	}
+
+	user, err := app.store.Users.GetUserByID(ctx, userID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if user.Role.Level >= adminRole.Level {
+		app.forbiddenResponse(w, r, errors.New("you can't change the role of this user"))
+		return
+	}
+
+	if err := app.store.Users.SetRole(ctx, userID, role.ID); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	entry, err := store.NewAuditEntry(store.AuditRoleChanged, &admin.ID, &userID, clientIP(r), map[string]any{
+		"role": role.Name,
+	})
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Audit.Record(ctx, entry); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type SetSchoolMemberPayload struct {
+	Role string `json:"role" validate:"required,oneof=admin moderator"`
+}
+
+// GetSchoolMembers godoc
+//
+//	@Summary		Fetches the school members
+//	@Description	Fetches the users with a role at the school
+//	@Tags			admin
+//	@Produce		json
+//	@Param			schoolID	path		int	true	"School ID"
+//	@Success		200			{array}		store.SchoolMembership
+//	@Failure		403			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/schools/{schoolID}/members [get]
+func (app *application) getSchoolMembersHandler(w http.ResponseWriter, r *http.Request) {
+	schoolID, err := strconv.ParseInt(chi.URLParam(r, "schoolID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	members, err := app.store.SchoolMemberships.ListBySchool(r.Context(), schoolID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, members); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// SetSchoolMember godoc
+//
+//	@Summary		Assigns a school role
+//	@Description	Makes the user an admin or moderator of a single school
+//	@Tags			admin
+//	@Accept			json
+//	@Param			schoolID	path	int						true	"School ID"
+//	@Param			userID		path	int						true	"User ID"
+//	@Param			payload		body	SetSchoolMemberPayload	true	"Role name"
+//	@Success		204
+//	@Failure		400	{object}	error
+//	@Failure		404	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/schools/{schoolID}/members/{userID} [put]
+func (app *application) setSchoolMemberHandler(w http.ResponseWriter, r *http.Request) {
+	schoolID, err := strconv.ParseInt(chi.URLParam(r, "schoolID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var payload SetSchoolMemberPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	role, err := app.store.Roles.GetRoleByName(ctx, payload.Role)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.SchoolMemberships.Set(ctx, userID, schoolID, role.ID); err != nil {
+		switch err {
+		case store.ErrNotFound, store.ErrInvalidSchool:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if !app.auditSchoolMember(w, r, store.AuditMemberChanged, userID, schoolID, role.Name) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveSchoolMember godoc
+//
+//	@Summary		Removes a school role
+//	@Description	Removes the role of the user at the school
+//	@Tags			admin
+//	@Param			schoolID	path	int	true	"School ID"
+//	@Param			userID		path	int	true	"User ID"
+//	@Success		204
+//	@Failure		404	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/schools/{schoolID}/members/{userID} [delete]
+func (app *application) removeSchoolMemberHandler(w http.ResponseWriter, r *http.Request) {
+	schoolID, err := strconv.ParseInt(chi.URLParam(r, "schoolID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := app.store.SchoolMemberships.Remove(r.Context(), userID, schoolID); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if !app.auditSchoolMember(w, r, store.AuditMemberRemoved, userID, schoolID, "") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) auditSchoolMember(w http.ResponseWriter, r *http.Request, action string, userID, schoolID int64, role string) bool {
+	admin := app.getUserFromCtx(r)
+
+	details := map[string]any{"school_id": schoolID}
+	if role != "" {
+		details["role"] = role
+	}
+
+	entry, err := store.NewAuditEntry(action, &admin.ID, &userID, clientIP(r), details)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return false
+	}
+
+	if err := app.store.Audit.Record(r.Context(), entry); err != nil {
+		app.internalServerError(w, r, err)
+		return false
+	}
+
+	return true
+}
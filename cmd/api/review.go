@@ -115,3 +115,67 @@ func (app *application) getTagsFromProfessorHandler(w http.ResponseWriter, r *ht
 		app.internalServerError(w, r, err)
 	}
 }
+
+// DeleteReview godoc
+//
+//	@Summary		Removes a review
+//	@Description	Moderators remove reviews that break the rules, the removal is audited
+//	@Tags			reviews
+//	@Param			reviewID	path	int	true	"Review ID"
+//	@Success		204
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/reviews/moderation/{reviewID} [delete]
+func (app *application) deleteReviewHandler(w http.ResponseWriter, r *http.Request) {
+	reviewID, err := strconv.ParseInt(chi.URLParam(r, "reviewID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	var review *store.Review
+	// school moderators only reach the reviews of their school
+	if param := chi.URLParam(r, "schoolID"); param != "" {
+		schoolID, parseErr := strconv.ParseInt(param, 10, 64)
+		if parseErr != nil {
+			app.badRequestResponse(w, r, parseErr)
+			return
+		}
+
+		review, err = app.store.Reviews.DeleteInSchool(ctx, reviewID, schoolID)
+	} else {
+		review, err = app.store.Reviews.Delete(ctx, reviewID)
+	}
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	moderator := app.getUserFromCtx(r)
+
+	entry, err := store.NewAuditEntry(store.AuditReviewDeleted, &moderator.ID, &review.UserID, clientIP(r), map[string]any{
+		"review_id":    review.ID,
+		"professor_id": review.ProfessorID,
+		"subject":      review.Subject,
+		"text":         review.Text,
+	})
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Audit.Record(ctx, entry); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/bruno120805/project/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/markbates/goth/gothic"
+)
+
+// GetSessions godoc
+//
+//	@Summary		Fetches the active sessions
+//	@Description	Fetches the browser sessions of the current user, the session of the request is marked as current
+//	@Tags			users
+//	@Produce		json
+//	@Success		200	{array}		store.Session
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/sessions [get]
+func (app *application) getSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.getUserFromCtx(r)
+
+	var current string
+	if session, err := gothic.Store.Get(r, "session"); err == nil {
+		current = session.ID
+	}
+
+	list, err := app.store.Sessions.ListByUser(r.Context(), user.ID, current)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, list); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// RevokeSession godoc
+//
+//	@Summary		Revokes a session
+//	@Description	Logs out a browser session of the current user
+//	@Tags			users
+//	@Param			sessionID	path	int	true	"Session ID"
+//	@Success		204
+//	@Failure		404	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/sessions/{sessionID} [delete]
+func (app *application) revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := strconv.ParseInt(chi.URLParam(r, "sessionID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.getUserFromCtx(r)
+
+	if err := app.store.Sessions.Revoke(r.Context(), user.ID, sessionID); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bruno120805/project/internal/mail"
+	"github.com/bruno120805/project/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type SuspendUserPayload struct {
+	Reason string `json:"reason" validate:"required,max=1000"`
+	// Until is when the suspension ends, without it the user is banned
+	Until       *time.Time `json:"until"`
+	HideContent bool       `json:"hide_content"`
+}
+
+// checkSuspension keeps suspended and banned users out, it writes the error
+// response with the reason otherwise.
+func (app *application) checkSuspension(w http.ResponseWriter, r *http.Request, user *store.User) bool {
+	suspension, err := app.store.Suspensions.GetActive(r.Context(), user.ID)
+	switch err {
+	case nil:
+	case store.ErrNotFound:
+		return true
+	default:
+		app.internalServerError(w, r, err)
+		return false
+	}
+
+	if suspension.IsBan() {
+		app.accountSuspendedResponse(w, r, fmt.Errorf("your account has been banned: %s", suspension.Reason))
+		return false
+	}
+
+	app.accountSuspendedResponse(w, r, fmt.Errorf("your account is suspended until %s: %s", *suspension.EndsAt, suspension.Reason))
+	return false
+}
+
+// SuspendUser godoc
+//
+//	@Summary		Suspends or bans a user
+//	@Description	Keeps a user below the admin out until the suspension ends, without an end the user is banned. The content of the user can be hidden meanwhile
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			userID	path		int					true	"User ID"
+//	@Param			payload	body		SuspendUserPayload	true	"Reason and end of the suspension"
+//	@Success		201		{object}	store.Suspension
+//	@Failure		400		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/users/{userID}/suspension [post]
+func (app *application) suspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var payload SuspendUserPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if payload.Until != nil && !payload.Until.After(time.Now()) {
+		app.badRequestResponse(w, r, errors.New("the suspension must end in the future"))
+		return
+	}
+
+	admin := app.getUserFromCtx(r)
+
+	if admin.ID == userID {
+		app.badRequestResponse(w, r, errors.New("you can't suspend yourself"))
+		return
+	}
+
+	ctx := r.Context()
+
+	user, err := app.store.Users.GetUserByID(ctx, userID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	// admins only suspend the users below their own level
+	adminRole, err := app.store.Roles.GetRoleByID(ctx, admin.Role.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if user.Role.Level >= adminRole.Level {
+		app.forbiddenResponse(w, r, errors.New("you can't suspend this user"))
+		return
+	}
+
+	suspension := &store.Suspension{
+		UserID:      user.ID,
+		Reason:      payload.Reason,
+		HideContent: payload.HideContent,
+		CreatedBy:   &admin.ID,
+	}
+
+	// the template tells bans apart by the empty end
+	var until string
+	if payload.Until != nil {
+		endsAt := payload.Until.UTC().Format(time.RFC3339)
+		suspension.EndsAt = &endsAt
+		until = payload.Until.UTC().Format("January 2, 2006 15:04 MST")
+	}
+
+	vars := struct {
+		Username string
+		Reason   string
+		Until    string
+	}{
+		Username: user.Username,
+		Reason:   payload.Reason,
+		Until:    until,
+	}
+
+	notice, err := store.NewOutboxEmail(mail.AccountSuspendedTemplate, user.Locale, user.Username, user.Email, vars)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Suspensions.Create(ctx, suspension, notice); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	action := store.AuditUserSuspended
+	if suspension.IsBan() {
+		action = store.AuditUserBanned
+	}
+
+	entry, err := store.NewAuditEntry(action, &admin.ID, &user.ID, clientIP(r), map[string]any{
+		"reason":       suspension.Reason,
+		"ends_at":      suspension.EndsAt,
+		"hide_content": suspension.HideContent,
+	})
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Audit.Record(ctx, entry); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, suspension); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// LiftSuspension godoc
+//
+//	@Summary		Lifts a suspension
+//	@Description	Ends the suspension or ban of the user right away and shows the hidden content again
+//	@Tags			admin
+//	@Param			userID	path	int	true	"User ID"
+//	@Success		204
+//	@Failure		404	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/users/{userID}/suspension [delete]
+func (app *application) liftSuspensionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	admin := app.getUserFromCtx(r)
+	ctx := r.Context()
+
+	if err := app.store.Suspensions.Lift(ctx, userID, admin.ID); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	entry, err := store.NewAuditEntry(store.AuditSuspensionLifted, &admin.ID, &userID, clientIP(r), nil)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Audit.Record(ctx, entry); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetUserSuspensions godoc
+//
+//	@Summary		Fetches the suspensions of a user
+//	@Description	Fetches every suspension and ban of the user, lifted and over ones included
+//	@Tags			admin
+//	@Produce		json
+//	@Param			userID	path		int	true	"User ID"
+//	@Success		200		{array}		store.Suspension
+//	@Failure		400		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/users/{userID}/suspensions [get]
+func (app *application) getUserSuspensionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	suspensions, err := app.store.Suspensions.ListByUser(r.Context(), userID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, suspensions); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
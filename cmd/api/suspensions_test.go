@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/bruno120805/project/internal/store"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// the fakes embed the Postgres stores for the methods the handler never
+// calls, calling one of them panics.
+
+type fakeUsers struct {
+	*store.UserStore
+	users map[int64]*store.User
+}
+
+func (f *fakeUsers) GetUserByID(_ context.Context, id int64) (*store.User, error) {
+	user, ok := f.users[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+
+	return user, nil
+}
+
+type fakeRoles struct {
+	*store.RoleStore
+	roles map[int64]*store.Role
+}
+
+func (f *fakeRoles) GetRoleByID(_ context.Context, id int64) (*store.Role, error) {
+	role, ok := f.roles[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+
+	return role, nil
+}
+
+type fakeSuspensions struct {
+	*store.SuspensionStore
+	created []*store.Suspension
+}
+
+func (f *fakeSuspensions) Create(_ context.Context, suspension *store.Suspension, _ *store.OutboxEmail) error {
+	suspension.ID = int64(len(f.created) + 1)
+	f.created = append(f.created, suspension)
+	return nil
+}
+
+type fakeAudit struct {
+	*store.AuditStore
+}
+
+func (f *fakeAudit) Record(context.Context, *store.AuditEntry) error {
+	return nil
+}
+
+var (
+	userRole      = store.Role{ID: 1, Name: "user", Level: 1}
+	moderatorRole = store.Role{ID: 2, Name: "moderator", Level: 2}
+	adminRole     = store.Role{ID: 3, Name: "admin", Level: 3}
+)
+
+func TestSuspendUserRoleLevel(t *testing.T) {
+	users := map[int64]*store.User{
+		1: {ID: 1, Username: "admin", Email: "admin@example.com", Role: adminRole},
+		2: {ID: 2, Username: "other-admin", Email: "other-admin@example.com", Role: adminRole},
+		3: {ID: 3, Username: "moderator", Email: "moderator@example.com", Role: moderatorRole},
+		4: {ID: 4, Username: "user", Email: "user@example.com", Role: userRole},
+	}
+
+	tests := []struct {
+		name   string
+		actor  int64
+		target int64
+		want   int
+	}{
+		{name: "admin suspends a user", actor: 1, target: 4, want: http.StatusCreated},
+		{name: "admin suspends a moderator", actor: 1, target: 3, want: http.StatusCreated},
+		{name: "admin can't suspend another admin", actor: 1, target: 2, want: http.StatusForbidden},
+		{name: "moderator suspends a user", actor: 3, target: 4, want: http.StatusCreated},
+		{name: "moderator can't suspend an admin", actor: 3, target: 1, want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			suspensions := &fakeSuspensions{}
+			app := &application{
+				logger: zap.NewNop().Sugar(),
+				store: store.Storage{
+					Users: &fakeUsers{users: users},
+					Roles: &fakeRoles{roles: map[int64]*store.Role{
+						userRole.ID:      &userRole,
+						moderatorRole.ID: &moderatorRole,
+						adminRole.ID:     &adminRole,
+					}},
+					Suspensions: suspensions,
+					Audit:       &fakeAudit{},
+				},
+			}
+
+			target := strconv.FormatInt(tt.target, 10)
+			r := httptest.NewRequest(http.MethodPost, "/v1/admin/users/"+target+"/suspension", strings.NewReader(`{"reason":"spam"}`))
+
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("userID", target)
+			ctx := context.WithValue(r.Context(), chi.RouteCtxKey, rctx)
+			ctx = context.WithValue(ctx, userKey, users[tt.actor])
+
+			rec := httptest.NewRecorder()
+			app.suspendUserHandler(rec, r.WithContext(ctx))
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+
+			if created := len(suspensions.created) == 1; created != (tt.want == http.StatusCreated) {
+				t.Errorf("suspension created = %v with status %d", created, rec.Code)
+			}
+		})
+	}
+}
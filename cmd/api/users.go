@@ -1,22 +1,50 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"github.com/bruno120805/project/internal/mail"
 	"github.com/bruno120805/project/internal/store"
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 )
 
+// PublicUser is the profile other users can see, it never includes the email.
+type PublicUser struct {
+	ID          int64  `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	SchoolID    *int64 `json:"school_id"`
+	CreatedAt   string `json:"created_at"`
+}
+
+func newPublicUser(user *store.User) *PublicUser {
+	return &PublicUser{
+		ID:          user.ID,
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		SchoolID:    user.SchoolID,
+		CreatedAt:   user.CreatedAt,
+	}
+}
+
 // GetUser godoc
 //
 //	@Summary		Fetches a user profile
-//	@Description	Fetches a user profile by ID
+//	@Description	Fetches the public profile of a user by ID
 //	@Tags			users
 //	@Accept			json
 //	@Produce		json
 //	@Param			userID	path		int	true	"User ID"
-//	@Success		200		{object}	store.User
+//	@Success		200		{object}	PublicUser
 //
 // Failure 400 {object} error
 // Failure 404 {object} error
@@ -44,11 +72,363 @@ func (app *application) getUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := app.jsonResponse(w, http.StatusOK, newPublicUser(user)); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// GetCurrentUserProfile godoc
+//
+//	@Summary		Fetches the profile of the current user
+//	@Description	Fetches the full profile of the authenticated user
+//	@Tags			users
+//	@Produce		json
+//	@Success		200	{object}	store.User
+//	@Failure		401	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me [get]
+func (app *application) getCurrentUserProfileHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.getUserFromCtx(r)
+
+	if err := app.jsonResponse(w, http.StatusOK, user); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+type UpdateProfilePayload struct {
+	Username    *string `json:"username" validate:"omitempty,min=1,max=60"`
+	DisplayName *string `json:"display_name" validate:"omitempty,max=100"`
+	Bio         *string `json:"bio" validate:"omitempty,max=500"`
+	// SchoolID set to 0 removes the school affiliation
+	SchoolID *int64 `json:"school_id" validate:"omitempty,gte=0"`
+	// Locale is the language of the emails sent to the user
+	Locale *string `json:"locale" validate:"omitempty,oneof=en es"`
+}
+
+// UpdateProfile godoc
+//
+//	@Summary		Updates the profile of the current user
+//	@Description	Updates the fields present in the payload
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		UpdateProfilePayload	true	"Profile fields"
+//	@Success		200		{object}	store.User
+//	@Failure		400		{object}	error
+//	@Failure		409		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me [patch]
+func (app *application) updateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	var payload UpdateProfilePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.getUserFromCtx(r)
+
+	if payload.Username != nil {
+		user.Username = *payload.Username
+	}
+
+	if payload.DisplayName != nil {
+		user.DisplayName = *payload.DisplayName
+	}
+
+	if payload.Bio != nil {
+		user.Bio = *payload.Bio
+	}
+
+	if payload.SchoolID != nil {
+		user.SchoolID = payload.SchoolID
+		if *payload.SchoolID == 0 {
+			user.SchoolID = nil
+		}
+	}
+
+	if payload.Locale != nil {
+		user.Locale = *payload.Locale
+	}
+
+	ctx := r.Context()
+
+	if err := app.store.Users.UpdateProfile(ctx, user); err != nil {
+		switch err {
+		case store.ErrDuplicateUsername:
+			app.conflictResponse(w, r, err)
+		case store.ErrInvalidSchool:
+			app.badRequestResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
 	if err := app.jsonResponse(w, http.StatusOK, user); err != nil {
 		app.internalServerError(w, r, err)
 	}
 }
 
+type ChangePasswordPayload struct {
+	CurrentPassword string `json:"current_password" validate:"required,max=72"`
+	NewPassword     string `json:"new_password" validate:"required,min=6,max=72"`
+}
+
+// ChangePassword godoc
+//
+//	@Summary		Changes the password of the current user
+//	@Description	Changes the password, the current password is required. Every other token, session and API key is revoked, the response has a new token
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		ChangePasswordPayload	true	"Passwords"
+//	@Success		200		{object}	UserWithToken
+//	@Failure		400		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/password [put]
+func (app *application) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ChangePasswordPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.getUserFromCtx(r)
+
+	if err := user.Password.Compare(payload.CurrentPassword); err != nil {
+		app.badRequestResponse(w, r, errors.New("current password is incorrect"))
+		return
+	}
+
+	if err := user.Password.Set(payload.NewPassword); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	if err := app.store.Users.UpdatePassword(ctx, user); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// the token of the request was revoked with the rest, keep this client in
+	token, err := app.generateUserToken(user)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"token": token,
+		"user":  user,
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, resp); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+type ChangeEmailPayload struct {
+	NewEmail string `json:"new_email" validate:"required,email,max=255"`
+	Password string `json:"password" validate:"required,max=72"`
+}
+
+// RequestEmailChange godoc
+//
+//	@Summary		Requests an email change
+//	@Description	Sends a confirmation link to the new email and notifies the current one
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		ChangeEmailPayload	true	"New email and current password"
+//	@Success		202		{string}	string				"Confirmation sent"
+//	@Failure		400		{object}	error
+//	@Failure		409		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/email [post]
+func (app *application) requestEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ChangeEmailPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.getUserFromCtx(r)
+
+	if err := user.Password.Compare(payload.Password); err != nil {
+		app.badRequestResponse(w, r, errors.New("current password is incorrect"))
+		return
+	}
+
+	if strings.EqualFold(payload.NewEmail, user.Email) {
+		app.badRequestResponse(w, r, errors.New("new email is the same as the current one"))
+		return
+	}
+
+	plainToken := uuid.New().String()
+
+	// store token in DB hashed
+	hash := sha256.Sum256([]byte(plainToken))
+	hashedToken := hex.EncodeToString(hash[:])
+
+	confirmVars := struct {
+		Username        string
+		ConfirmationURL string
+		ExpiresInHours  int
+	}{
+		Username:        user.Username,
+		ConfirmationURL: fmt.Sprintf("%s/confirm-email/%s", app.config.frontendURL, plainToken),
+		ExpiresInHours:  int(app.config.mail.emailChangeExp.Hours()),
+	}
+
+	confirmEmail, err := store.NewOutboxEmail(mail.EmailChangeConfirmTemplate, user.Locale, user.Username, payload.NewEmail, confirmVars)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	noticeVars := struct {
+		Username string
+		NewEmail string
+	}{
+		Username: user.Username,
+		NewEmail: payload.NewEmail,
+	}
+
+	noticeEmail, err := store.NewOutboxEmail(mail.EmailChangeNoticeTemplate, user.Locale, user.Username, user.Email, noticeVars)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	err = app.store.Users.CreateEmailChangeRequest(ctx, user.ID, payload.NewEmail, hashedToken, app.config.mail.emailChangeExp, confirmEmail, noticeEmail)
+	if err != nil {
+		switch err {
+		case store.ErrDuplicateEmail:
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusAccepted, "Confirmation sent"); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// ConfirmEmailChange godoc
+//
+//	@Summary		Confirms an email change
+//	@Description	Swaps the user email and logs out every session
+//	@Tags			users
+//	@Produce		json
+//	@Param			token	path		string	true	"Email change token"
+//	@Success		200		{string}	string	"Email changed"
+//	@Failure		404		{object}	error
+//	@Failure		409		{object}	error
+//	@Router			/users/email/confirm/{token} [put]
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+
+	ctx := r.Context()
+
+	if _, err := app.store.Users.ConfirmEmailChange(ctx, token); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		case store.ErrDuplicateEmail:
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, "Email changed"); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+type ResendActivationPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+// ResendActivation godoc
+//
+//	@Summary		Resends the activation email
+//	@Description	Creates a new invitation token for a not activated account and emails it
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		ResendActivationPayload	true	"Account email"
+//	@Success		202		{string}	string					"Activation email sent"
+//	@Failure		400		{object}	error
+//	@Router			/users/activation/resend [post]
+func (app *application) resendActivationHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ResendActivationPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	plainToken := uuid.New().String()
+
+	// store token in DB hashed
+	hash := sha256.Sum256([]byte(plainToken))
+	hashedToken := hex.EncodeToString(hash[:])
+
+	ctx := r.Context()
+
+	user, err := app.store.Users.RenewInvitation(ctx, payload.Email, hashedToken, app.config.mail.exp)
+	switch err {
+	case nil:
+		welcome, err := newWelcomeEmail(app.config.frontendURL, user, plainToken)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		if err := app.store.Outbox.Enqueue(ctx, welcome); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+	case store.ErrNotFound:
+		// same response as a sent email so accounts can't be enumerated
+		app.logger.Infow("activation resend for unknown or active account", "email", payload.Email)
+	default:
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusAccepted, "Activation email sent"); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
 // ActivateUser gdoc
 //
 //	@Summary		Activates/Register a user
@@ -83,6 +463,95 @@ func (app *application) activateUserTokenHandler(w http.ResponseWriter, r *http.
 
 }
 
+// GetStorageUsage godoc
+//
+//	@Summary		Fetches the storage usage of the current user
+//	@Description	Fetches the bytes used by the notes of the user and its quota
+//	@Tags			users
+//	@Produce		json
+//	@Success		200	{object}	store.StorageUsage
+//	@Failure		401	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/usage [get]
+func (app *application) getStorageUsageHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.getUserFromCtx(r)
+
+	ctx := r.Context()
+
+	usage, err := app.store.Quotas.GetUsage(ctx, user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, usage); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+type UpdateQuotaPayload struct {
+	// QuotaBytes set to null removes the override and the user gets the quota
+	// of its role again
+	QuotaBytes *int64 `json:"quota_bytes" validate:"omitempty,gte=0"`
+}
+
+// UpdateUserQuota godoc
+//
+//	@Summary		Overrides the storage quota of a user
+//	@Description	Overrides the storage quota of a user, admins only
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			userID	path		int					true	"User ID"
+//	@Param			payload	body		UpdateQuotaPayload	true	"Quota in bytes"
+//	@Success		200		{object}	store.StorageUsage
+//	@Failure		400		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/{userID}/quota [put]
+func (app *application) updateUserQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var payload UpdateQuotaPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	if err := app.store.Quotas.SetUserQuota(ctx, userID, payload.QuotaBytes); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	usage, err := app.store.Quotas.GetUsage(ctx, userID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, usage); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
 func (app *application) getUserFromCtx(r *http.Request) *store.User {
 	ctx := r.Context()
 
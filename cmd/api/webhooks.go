@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/bruno120805/project/internal/mail"
+	"github.com/bruno120805/project/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+// webhookMaxBytes bounds the webhook bodies, SendGrid batches many events
+// in a single request
+const webhookMaxBytes = 5 << 20
+
+// SendGridWebhook godoc
+//
+//	@Summary		Receives SendGrid delivery events
+//	@Description	Suppresses the addresses that hard bounced or marked an email as spam
+//	@Tags			webhooks
+//	@Accept			json
+//	@Success		204
+//	@Failure		400	{object}	error
+//	@Failure		401	{object}	error
+//	@Router			/webhooks/sendgrid [post]
+func (app *application) sendGridWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, webhookMaxBytes))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err = mail.VerifySendGridSignature(
+		app.config.mail.webhooks.sendGridPublicKey,
+		r.Header.Get("X-Twilio-Email-Event-Webhook-Signature"),
+		r.Header.Get("X-Twilio-Email-Event-Webhook-Timestamp"),
+		body,
+	)
+	if err != nil {
+		app.unauthorizedResponse(w, r, err)
+		return
+	}
+
+	events, err := mail.ParseSendGridEvents(body)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	app.recordDeliveryEvents(w, r, events)
+}
+
+// ResendWebhook godoc
+//
+//	@Summary		Receives Resend delivery events
+//	@Description	Suppresses the addresses that hard bounced or marked an email as spam
+//	@Tags			webhooks
+//	@Accept			json
+//	@Success		204
+//	@Failure		400	{object}	error
+//	@Failure		401	{object}	error
+//	@Router			/webhooks/resend [post]
+func (app *application) resendWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, webhookMaxBytes))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err = mail.VerifyResendSignature(
+		app.config.mail.webhooks.resendSecret,
+		r.Header.Get("svix-id"),
+		r.Header.Get("svix-timestamp"),
+		r.Header.Get("svix-signature"),
+		body,
+	)
+	if err != nil {
+		app.unauthorizedResponse(w, r, err)
+		return
+	}
+
+	events, err := mail.ParseResendEvent(body)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	app.recordDeliveryEvents(w, r, events)
+}
+
+func (app *application) recordDeliveryEvents(w http.ResponseWriter, r *http.Request, events []mail.DeliveryEvent) {
+	ctx := r.Context()
+
+	for _, e := range events {
+		if e.Email == "" {
+			continue
+		}
+
+		occurredAt := e.OccurredAt
+		if occurredAt.IsZero() || occurredAt.Unix() <= 0 {
+			occurredAt = time.Now()
+		}
+
+		// a failure makes the provider retry the whole batch, recording the
+		// same event twice only bumps the events count
+		if err := app.store.Suppressions.Record(ctx, e.Email, e.Kind, e.Reason, e.Provider, occurredAt); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		app.logger.Infow("Email address suppressed", "provider", e.Provider, "reason", e.Kind)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetSuppressions godoc
+//
+//	@Summary		Lists the suppressed email addresses
+//	@Description	Lists the addresses that bounced or complained, admins only
+//	@Tags			admin
+//	@Produce		json
+//	@Param			reason	query		string	false	"hard_bounce or complaint"
+//	@Param			limit	query		int		false	"Limit"
+//	@Param			offset	query		int		false	"Offset"
+//	@Success		200		{array}		store.EmailSuppression
+//	@Failure		400		{object}	error
+//	@Failure		403		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/suppressions [get]
+func (app *application) getSuppressionsHandler(w http.ResponseWriter, r *http.Request) {
+	reason := r.URL.Query().Get("reason")
+	if err := Validate.Var(reason, "omitempty,oneof=hard_bounce complaint"); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	fq := store.PaginatedFeedQuery{
+		Limit:  20,
+		Offset: 0,
+		Sort:   "desc",
+	}
+
+	fq, err := fq.Parse(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(fq); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	suppressions, err := app.store.Suppressions.List(r.Context(), reason, fq)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, suppressions); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// DeleteSuppression godoc
+//
+//	@Summary		Lifts the suppression of an email address
+//	@Description	Allows sending emails to the address again, admins only
+//	@Tags			admin
+//	@Param			email	path	string	true	"Email address"
+//	@Success		204
+//	@Failure		404	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/suppressions/{email} [delete]
+func (app *application) deleteSuppressionHandler(w http.ResponseWriter, r *http.Request) {
+	email := chi.URLParam(r, "email")
+	if err := Validate.Var(email, "required,email"); err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid email"))
+		return
+	}
+
+	if err := app.store.Suppressions.Delete(r.Context(), email); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS comments (
+  id bigserial PRIMARY KEY,
+  note_id bigint NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+  user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  parent_id bigint REFERENCES comments(id) ON DELETE CASCADE,
+  content TEXT NOT NULL,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  deleted_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS idx_comments_note_id ON comments(note_id);
+
+CREATE INDEX IF NOT EXISTS idx_comments_parent_id ON comments(parent_id);
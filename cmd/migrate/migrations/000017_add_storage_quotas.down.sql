@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS storage_usage;
+
+DROP TABLE IF EXISTS note_files;
+
+ALTER TABLE
+  users DROP COLUMN IF EXISTS storage_quota_bytes;
+
+ALTER TABLE
+  roles DROP COLUMN IF EXISTS storage_quota_bytes;
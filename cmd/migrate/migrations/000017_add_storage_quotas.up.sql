@@ -0,0 +1,49 @@
+ALTER TABLE
+  roles
+ADD
+  COLUMN storage_quota_bytes bigint NOT NULL DEFAULT 104857600;
+
+UPDATE
+  roles
+SET
+  storage_quota_bytes = 1073741824
+WHERE
+  name = 'admin';
+
+-- NULL means the user gets the quota of its role
+ALTER TABLE
+  users
+ADD
+  COLUMN storage_quota_bytes bigint;
+
+CREATE TABLE IF NOT EXISTS note_files (
+  id bigserial PRIMARY KEY,
+  note_id bigint NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+  user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  file_key TEXT NOT NULL,
+  file_name TEXT NOT NULL,
+  size_bytes bigint NOT NULL,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_files_note_id ON note_files(note_id);
+
+CREATE TABLE IF NOT EXISTS storage_usage (
+  user_id bigint PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  bytes_used bigint NOT NULL DEFAULT 0 CHECK (bytes_used >= 0),
+  files_count int NOT NULL DEFAULT 0 CHECK (files_count >= 0),
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- the notes uploaded before the accounting count against the quota of their
+-- authors. Their files only have a URL, the sizes were never stored, so they
+-- count in files_count and their bytes are left out
+INSERT INTO
+  storage_usage (user_id, files_count)
+SELECT
+  user_id,
+  SUM(COALESCE(cardinality(NULLIF(files_url, '')::text[]), 0))
+FROM
+  notes
+GROUP BY
+  user_id ON CONFLICT (user_id) DO NOTHING;
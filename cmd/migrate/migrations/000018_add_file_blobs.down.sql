@@ -0,0 +1,4 @@
+ALTER TABLE
+  note_files DROP COLUMN IF EXISTS sha256;
+
+DROP TABLE IF EXISTS file_blobs;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS file_blobs (
+  sha256 char(64) PRIMARY KEY,
+  file_key TEXT NOT NULL UNIQUE,
+  size_bytes bigint NOT NULL,
+  ref_count int NOT NULL DEFAULT 0 CHECK (ref_count >= 0),
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE
+  note_files
+ADD
+  COLUMN sha256 char(64) REFERENCES file_blobs(sha256);
+
+CREATE INDEX IF NOT EXISTS idx_note_files_sha256 ON note_files(sha256);
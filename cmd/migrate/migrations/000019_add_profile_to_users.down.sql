@@ -0,0 +1,8 @@
+ALTER TABLE
+  users DROP COLUMN IF EXISTS school_id;
+
+ALTER TABLE
+  users DROP COLUMN IF EXISTS bio;
+
+ALTER TABLE
+  users DROP COLUMN IF EXISTS display_name;
@@ -0,0 +1,14 @@
+ALTER TABLE
+  users
+ADD
+  COLUMN display_name VARCHAR(100) NOT NULL DEFAULT '';
+
+ALTER TABLE
+  users
+ADD
+  COLUMN bio TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE
+  users
+ADD
+  COLUMN school_id bigint REFERENCES school(id) ON DELETE SET NULL;
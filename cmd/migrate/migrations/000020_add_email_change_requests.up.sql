@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS email_change_requests (
+  token bytea PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  new_email citext NOT NULL,
+  expiry timestamp(0) with time zone NOT NULL
+);
+
+-- bumping the version invalidates every token issued before
+ALTER TABLE
+  users
+ADD
+  COLUMN token_version int NOT NULL DEFAULT 0;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS email_outbox (
+  id bigserial PRIMARY KEY,
+  template VARCHAR(255) NOT NULL,
+  recipient_name VARCHAR(255) NOT NULL,
+  recipient_email citext NOT NULL,
+  data jsonb NOT NULL DEFAULT '{}',
+  status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (
+    status IN ('pending', 'sending', 'sent', 'dead')
+  ),
+  attempts int NOT NULL DEFAULT 0,
+  next_attempt_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  last_error TEXT NOT NULL DEFAULT '',
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  sent_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_outbox_status_next_attempt ON email_outbox(status, next_attempt_at);
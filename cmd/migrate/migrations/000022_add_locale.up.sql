@@ -0,0 +1,9 @@
+ALTER TABLE
+  users
+ADD
+  COLUMN locale VARCHAR(10) NOT NULL DEFAULT 'en';
+
+ALTER TABLE
+  email_outbox
+ADD
+  COLUMN locale VARCHAR(10) NOT NULL DEFAULT 'en';
@@ -0,0 +1,19 @@
+DELETE FROM email_outbox WHERE status = 'skipped';
+
+ALTER TABLE
+  email_outbox DROP CONSTRAINT IF EXISTS email_outbox_status_check;
+
+ALTER TABLE
+  email_outbox
+ADD
+  CONSTRAINT email_outbox_status_check CHECK (
+    status IN ('pending', 'sending', 'sent', 'dead')
+  );
+
+ALTER TABLE
+  email_outbox DROP COLUMN IF EXISTS category;
+
+ALTER TABLE
+  email_outbox DROP COLUMN IF EXISTS user_id;
+
+DROP TABLE IF EXISTS notification_preferences;
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS notification_preferences (
+  user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  category VARCHAR(50) NOT NULL,
+  enabled boolean NOT NULL,
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (user_id, category)
+);
+
+ALTER TABLE
+  email_outbox
+ADD
+  COLUMN user_id bigint REFERENCES users(id) ON DELETE CASCADE;
+
+ALTER TABLE
+  email_outbox
+ADD
+  COLUMN category VARCHAR(50) NOT NULL DEFAULT '';
+
+ALTER TABLE
+  email_outbox DROP CONSTRAINT IF EXISTS email_outbox_status_check;
+
+ALTER TABLE
+  email_outbox
+ADD
+  CONSTRAINT email_outbox_status_check CHECK (
+    status IN ('pending', 'sending', 'sent', 'dead', 'skipped')
+  );
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS email_suppressions (
+  email citext PRIMARY KEY,
+  user_id bigint REFERENCES users(id) ON DELETE SET NULL,
+  reason VARCHAR(20) NOT NULL CHECK (reason IN ('hard_bounce', 'complaint')),
+  detail TEXT NOT NULL DEFAULT '',
+  provider VARCHAR(20) NOT NULL,
+  events_count int NOT NULL DEFAULT 1,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_suppressions_user_id ON email_suppressions(user_id);
@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS mfa_recovery_codes;
+
+ALTER TABLE
+  users DROP COLUMN IF EXISTS mfa_last_step;
+
+ALTER TABLE
+  users DROP COLUMN IF EXISTS mfa_enabled;
+
+ALTER TABLE
+  users DROP COLUMN IF EXISTS mfa_secret;
@@ -0,0 +1,24 @@
+ALTER TABLE
+  users
+ADD
+  COLUMN mfa_secret TEXT;
+
+ALTER TABLE
+  users
+ADD
+  COLUMN mfa_enabled boolean NOT NULL DEFAULT false;
+
+-- last TOTP time step used, codes of that step or older are rejected
+ALTER TABLE
+  users
+ADD
+  COLUMN mfa_last_step bigint NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+  id bigserial PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  code_hash VARCHAR(64) NOT NULL,
+  used_at timestamp(0) with time zone,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  UNIQUE (user_id, code_hash)
+);
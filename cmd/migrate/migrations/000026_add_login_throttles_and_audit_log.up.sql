@@ -0,0 +1,23 @@
+-- failed logins by account (email) or by client IP
+CREATE TABLE IF NOT EXISTS login_throttles (
+  scope VARCHAR(10) NOT NULL CHECK (scope IN ('account', 'ip')),
+  key citext NOT NULL,
+  failures int NOT NULL DEFAULT 0,
+  lockouts int NOT NULL DEFAULT 0,
+  last_failure_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  locked_until timestamp(0) with time zone,
+  PRIMARY KEY (scope, key)
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+  id bigserial PRIMARY KEY,
+  action VARCHAR(50) NOT NULL,
+  actor_id bigint REFERENCES users(id) ON DELETE SET NULL,
+  target_user_id bigint REFERENCES users(id) ON DELETE SET NULL,
+  ip VARCHAR(45) NOT NULL DEFAULT '',
+  details jsonb NOT NULL DEFAULT '{}',
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log(created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_target_user_id ON audit_log(target_user_id);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS user_identities (
+  id bigserial PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  provider VARCHAR(50) NOT NULL,
+  provider_user_id VARCHAR(255) NOT NULL,
+  email citext NOT NULL DEFAULT '',
+  email_verified boolean NOT NULL DEFAULT false,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  last_login_at timestamp(0) with time zone,
+  CONSTRAINT user_identities_provider_user_key UNIQUE (provider, provider_user_id),
+  CONSTRAINT user_identities_user_provider_key UNIQUE (user_id, provider)
+);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS sessions (
+  id bigserial PRIMARY KEY,
+  token_hash bytea NOT NULL UNIQUE,
+  name VARCHAR(100) NOT NULL,
+  user_id bigint REFERENCES users(id) ON DELETE CASCADE,
+  data bytea NOT NULL,
+  user_agent TEXT NOT NULL DEFAULT '',
+  ip VARCHAR(45) NOT NULL DEFAULT '',
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  last_seen_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  expires_at timestamp(0) with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions (expires_at);
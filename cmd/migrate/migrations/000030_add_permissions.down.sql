@@ -0,0 +1,36 @@
+UPDATE
+  users
+SET
+  role_id = (
+    SELECT
+      id
+    FROM
+      roles
+    WHERE
+      name = 'user'
+  )
+WHERE
+  role_id = (
+    SELECT
+      id
+    FROM
+      roles
+    WHERE
+      name = 'moderator'
+  );
+
+DELETE FROM
+  roles
+WHERE
+  name = 'moderator';
+
+UPDATE
+  roles
+SET
+  level = 2
+WHERE
+  name = 'admin';
+
+DROP TABLE IF EXISTS role_permissions;
+
+DROP TABLE IF EXISTS permissions;
@@ -0,0 +1,59 @@
+CREATE TABLE IF NOT EXISTS permissions (
+  id bigserial PRIMARY KEY,
+  name varchar(100) NOT NULL UNIQUE,
+  description TEXT
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+  role_id bigint NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+  permission_id bigint NOT NULL REFERENCES permissions(id) ON DELETE CASCADE,
+  PRIMARY KEY (role_id, permission_id)
+);
+
+INSERT INTO
+  permissions(name, description)
+VALUES
+  ('school:create', 'Create schools'),
+  ('professor:create', 'Add professors to a school'),
+  ('review:moderate', 'Remove reviews of any user'),
+  ('note:delete:any', 'Delete notes of any user'),
+  ('comment:delete:any', 'Delete comments of any user'),
+  ('user:manage', 'Change quotas, roles and unlock accounts'),
+  ('mail:manage', 'Inspect the outbox and the suppression list'),
+  ('audit:read', 'Read the audit log');
+
+-- the moderator sits between the user and the admin
+UPDATE
+  roles
+SET
+  level = 3
+WHERE
+  name = 'admin';
+
+INSERT INTO
+  roles(name, description, level)
+VALUES
+  (
+    'moderator',
+    'A moderator can remove reviews, notes and comments of other users',
+    2
+  );
+
+INSERT INTO
+  role_permissions(role_id, permission_id)
+SELECT
+  r.id,
+  p.id
+FROM
+  roles r,
+  permissions p
+WHERE
+  r.name = 'admin'
+  OR (
+    r.name = 'moderator'
+    AND p.name IN (
+      'review:moderate',
+      'note:delete:any',
+      'comment:delete:any'
+    )
+  );
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS school_memberships (
+  user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  school_id bigint NOT NULL REFERENCES school(id) ON DELETE CASCADE,
+  role_id bigint NOT NULL REFERENCES roles(id),
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (user_id, school_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_school_memberships_school_id ON school_memberships (school_id);
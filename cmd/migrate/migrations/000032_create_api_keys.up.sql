@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+  id bigserial PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  name VARCHAR(100) NOT NULL,
+  prefix VARCHAR(16) NOT NULL,
+  key_hash bytea NOT NULL UNIQUE,
+  scopes TEXT[] NOT NULL DEFAULT '{}',
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  last_used_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys (user_id);
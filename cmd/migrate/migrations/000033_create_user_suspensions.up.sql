@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS user_suspensions (
+  id bigserial PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  reason TEXT NOT NULL,
+  ends_at timestamp(0) with time zone,
+  hide_content boolean NOT NULL DEFAULT false,
+  created_by bigint REFERENCES users(id) ON DELETE SET NULL,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  lifted_at timestamp(0) with time zone,
+  lifted_by bigint REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_suspensions_user_id ON user_suspensions (user_id);
@@ -0,0 +1,14 @@
+DELETE FROM login_throttles WHERE scope = 'magic_link';
+
+ALTER TABLE
+  login_throttles DROP CONSTRAINT IF EXISTS login_throttles_scope_check;
+
+ALTER TABLE
+  login_throttles
+ADD
+  CONSTRAINT login_throttles_scope_check CHECK (scope IN ('account', 'ip'));
+
+ALTER TABLE
+  login_throttles
+ALTER COLUMN
+  scope TYPE VARCHAR(10);
@@ -0,0 +1,13 @@
+-- the magic link requests are throttled by email in their own scope
+ALTER TABLE
+  login_throttles
+ALTER COLUMN
+  scope TYPE VARCHAR(20);
+
+ALTER TABLE
+  login_throttles DROP CONSTRAINT IF EXISTS login_throttles_scope_check;
+
+ALTER TABLE
+  login_throttles
+ADD
+  CONSTRAINT login_throttles_scope_check CHECK (scope IN ('account', 'ip', 'magic_link'));
@@ -0,0 +1 @@
+-- the cleared data can't be restored
@@ -0,0 +1,7 @@
+-- the data of sent emails can hold the plaintext tokens of their links
+UPDATE
+  email_outbox
+SET
+  data = '{}'
+WHERE
+  status IN ('sent', 'skipped');
@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// APIKeyPrefix marks the keys so they are easy to spot in scripts and
+// secret scanners.
+const APIKeyPrefix = "gsk_"
+
+// apiKeyDisplayLen is how much of the key is kept in clear to tell keys apart.
+const apiKeyDisplayLen = len(APIKeyPrefix) + 8
+
+// GenerateAPIKey returns a new API key and the start of it that can be
+// shown after the key itself is gone.
+func GenerateAPIKey() (key, prefix string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	key = APIKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, key[:apiKeyDisplayLen], nil
+}
+
+// HashAPIKey hashes an API key to be stored or looked up.
+func HashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/markbates/goth"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oauthCookieName = "oauth_state"
+	oauthCookieAge  = 10 * time.Minute
+
+	// GoogleUserInfoURL is the OpenID Connect userinfo endpoint of Google.
+	GoogleUserInfoURL = "https://openidconnect.googleapis.com/v1/userinfo"
+)
+
+var ErrInvalidOAuthState = errors.New("invalid or expired OAuth state")
+
+// OAuthFlow runs the authorization code flow with PKCE. The state and code
+// verifier of a login live in a signed cookie until the callback.
+type OAuthFlow struct {
+	config      *oauth2.Config
+	userInfoURL string
+	cookies     *securecookie.SecureCookie
+	secure      bool
+}
+
+type oauthState struct {
+	State    string `json:"s"`
+	Verifier string `json:"v"`
+}
+
+func NewOAuthFlow(config *oauth2.Config, userInfoURL, secret string, secure bool) *OAuthFlow {
+	cookies := securecookie.New([]byte(secret), nil)
+	cookies.MaxAge(int(oauthCookieAge.Seconds()))
+	cookies.SetSerializer(securecookie.JSONEncoder{})
+
+	return &OAuthFlow{
+		config:      config,
+		userInfoURL: userInfoURL,
+		cookies:     cookies,
+		secure:      secure,
+	}
+}
+
+// Begin stores a new state and code verifier and returns the URL of the
+// authorization server.
+func (f *OAuthFlow) Begin(w http.ResponseWriter) (string, error) {
+	state, err := randomState()
+	if err != nil {
+		return "", err
+	}
+
+	s := oauthState{State: state, Verifier: oauth2.GenerateVerifier()}
+
+	value, err := f.cookies.Encode(oauthCookieName, s)
+	if err != nil {
+		return "", err
+	}
+
+	f.setCookie(w, value, int(oauthCookieAge.Seconds()))
+
+	return f.config.AuthCodeURL(s.State, oauth2.AccessTypeOffline, oauth2.S256ChallengeOption(s.Verifier)), nil
+}
+
+// Exchange checks the state of the callback and exchanges the code, the
+// state can only be used once.
+func (f *OAuthFlow) Exchange(w http.ResponseWriter, r *http.Request) (*oauth2.Token, error) {
+	cookie, err := r.Cookie(oauthCookieName)
+	if err != nil {
+		return nil, ErrInvalidOAuthState
+	}
+
+	f.setCookie(w, "", -1)
+
+	var s oauthState
+	if err := f.cookies.Decode(oauthCookieName, cookie.Value, &s); err != nil {
+		return nil, ErrInvalidOAuthState
+	}
+
+	query := r.URL.Query()
+	if s.State == "" || s.Verifier == "" || query.Get("state") != s.State {
+		return nil, ErrInvalidOAuthState
+	}
+
+	if errCode := query.Get("error"); errCode != "" {
+		return nil, fmt.Errorf("authorization denied: %s", errCode)
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		return nil, errors.New("missing authorization code")
+	}
+
+	return f.config.Exchange(r.Context(), code, oauth2.VerifierOption(s.Verifier))
+}
+
+// UserInfo fetches the OpenID Connect profile of the token owner.
+func (f *OAuthFlow) UserInfo(ctx context.Context, provider string, token *oauth2.Token) (goth.User, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.userInfoURL, nil)
+	if err != nil {
+		return goth.User{}, err
+	}
+
+	res, err := f.config.Client(ctx, token).Do(req)
+	if err != nil {
+		return goth.User{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return goth.User{}, fmt.Errorf("userinfo returned %d", res.StatusCode)
+	}
+
+	claims := map[string]interface{}{}
+	if err := json.NewDecoder(res.Body).Decode(&claims); err != nil {
+		return goth.User{}, err
+	}
+
+	str := func(key string) string {
+		v, _ := claims[key].(string)
+		return v
+	}
+
+	return goth.User{
+		RawData:      claims,
+		Provider:     provider,
+		UserID:       str("sub"),
+		Email:        str("email"),
+		Name:         str("name"),
+		FirstName:    str("given_name"),
+		LastName:     str("family_name"),
+		AvatarURL:    str("picture"),
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		ExpiresAt:    token.Expiry,
+	}, nil
+}
+
+func (f *OAuthFlow) setCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthCookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   f.secure,
+		// the callback is a top level navigation from the provider
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func randomState() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,277 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+// stubAuthServer issues codes bound to the PKCE challenge of the
+// authorization request and only exchanges them with the matching verifier.
+type stubAuthServer struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	challenges map[string]string
+}
+
+func newStubAuthServer(t *testing.T) *stubAuthServer {
+	t.Helper()
+
+	srv := &stubAuthServer{challenges: map[string]string{}}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		srv.mu.Lock()
+		challenge, ok := srv.challenges[r.PostForm.Get("code")]
+		srv.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+
+		writeJSON(t, w, map[string]any{
+			"access_token": stubAccessToken,
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	})
+
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+stubAccessToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		writeJSON(t, w, map[string]any{
+			"sub":            stubSubject,
+			"email":          "gopher@example.com",
+			"email_verified": true,
+		})
+	})
+
+	srv.Server = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// authorize plays the user approving the login, it returns the callback
+// query for the authorization URL.
+func (s *stubAuthServer) authorize(t *testing.T, authURL string) url.Values {
+	t.Helper()
+
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("authorization URL %s has no S256 challenge", authURL)
+	}
+
+	code := "code-" + query.Get("state")[:8]
+
+	s.mu.Lock()
+	s.challenges[code] = query.Get("code_challenge")
+	s.mu.Unlock()
+
+	return url.Values{"code": {code}, "state": {query.Get("state")}}
+}
+
+func newTestFlow(srv *stubAuthServer) *OAuthFlow {
+	config := &oauth2.Config{
+		ClientID:     stubClientID,
+		ClientSecret: stubClientSecret,
+		RedirectURL:  "http://localhost/callback",
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  srv.URL + "/authorize",
+			TokenURL: srv.URL + "/token",
+		},
+	}
+
+	return NewOAuthFlow(config, srv.URL+"/userinfo", testSecret, false)
+}
+
+// begin starts a login and returns the state cookie and the callback query.
+func begin(t *testing.T, flow *OAuthFlow, srv *stubAuthServer) (*http.Cookie, url.Values) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	authURL, err := flow.Begin(rec)
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != oauthCookieName {
+		t.Fatalf("Begin set cookies %v, want the state cookie", cookies)
+	}
+
+	return cookies[0], srv.authorize(t, authURL)
+}
+
+func callback(query url.Values, cookie *http.Cookie) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/callback?"+query.Encode(), nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+
+	return r
+}
+
+func TestOAuthFlow(t *testing.T) {
+	srv := newStubAuthServer(t)
+	flow := newTestFlow(srv)
+
+	cookie, query := begin(t, flow, srv)
+
+	rec := httptest.NewRecorder()
+	token, err := flow.Exchange(rec, callback(query, cookie))
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+
+	// the state is single use
+	cleared := rec.Result().Cookies()
+	if len(cleared) != 1 || cleared[0].MaxAge >= 0 {
+		t.Errorf("Exchange did not clear the state cookie: %v", cleared)
+	}
+
+	user, err := flow.UserInfo(context.Background(), ProviderGoogle, token)
+	if err != nil {
+		t.Fatalf("UserInfo: %v", err)
+	}
+
+	if user.UserID != stubSubject || user.Email != "gopher@example.com" || user.Provider != ProviderGoogle {
+		t.Errorf("unexpected user %+v", user)
+	}
+}
+
+func TestOAuthFlowRejectsInvalidState(t *testing.T) {
+	srv := newStubAuthServer(t)
+	flow := newTestFlow(srv)
+
+	tests := []struct {
+		name  string
+		setup func(t *testing.T) (url.Values, *http.Cookie)
+	}{
+		{
+			name: "state mismatch",
+			setup: func(t *testing.T) (url.Values, *http.Cookie) {
+				cookie, query := begin(t, flow, srv)
+				query.Set("state", "forged")
+				return query, cookie
+			},
+		},
+		{
+			name: "state of another login",
+			setup: func(t *testing.T) (url.Values, *http.Cookie) {
+				cookie, _ := begin(t, flow, srv)
+				_, other := begin(t, flow, srv)
+				return other, cookie
+			},
+		},
+		{
+			name: "missing cookie",
+			setup: func(t *testing.T) (url.Values, *http.Cookie) {
+				_, query := begin(t, flow, srv)
+				return query, nil
+			},
+		},
+		{
+			name: "missing verifier",
+			setup: func(t *testing.T) (url.Values, *http.Cookie) {
+				cookie, query := begin(t, flow, srv)
+
+				value, err := flow.cookies.Encode(oauthCookieName, oauthState{State: query.Get("state")})
+				if err != nil {
+					t.Fatal(err)
+				}
+				cookie.Value = value
+
+				return query, cookie
+			},
+		},
+		{
+			name: "cookie signed with another secret",
+			setup: func(t *testing.T) (url.Values, *http.Cookie) {
+				other := NewOAuthFlow(flow.config, flow.userInfoURL, "another-secret-of-at-least-32-bytes", false)
+				cookie, query := begin(t, other, srv)
+				return query, cookie
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, cookie := tt.setup(t)
+
+			_, err := flow.Exchange(httptest.NewRecorder(), callback(query, cookie))
+			if !errors.Is(err, ErrInvalidOAuthState) {
+				t.Errorf("Exchange error = %v, want ErrInvalidOAuthState", err)
+			}
+		})
+	}
+}
+
+func TestOAuthFlowRejectsWrongVerifier(t *testing.T) {
+	srv := newStubAuthServer(t)
+	flow := newTestFlow(srv)
+
+	cookie, query := begin(t, flow, srv)
+
+	// a valid cookie for the state whose verifier doesn't match the challenge
+	value, err := flow.cookies.Encode(oauthCookieName, oauthState{
+		State:    query.Get("state"),
+		Verifier: oauth2.GenerateVerifier(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie.Value = value
+
+	if _, err := flow.Exchange(httptest.NewRecorder(), callback(query, cookie)); err == nil {
+		t.Error("expected the authorization server to reject the verifier")
+	}
+}
+
+func TestOAuthFlowRejectsExpiredCookie(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the cookie to expire")
+	}
+
+	srv := newStubAuthServer(t)
+	flow := newTestFlow(srv)
+	flow.cookies.MaxAge(1)
+
+	cookie, query := begin(t, flow, srv)
+
+	time.Sleep(2100 * time.Millisecond)
+
+	_, err := flow.Exchange(httptest.NewRecorder(), callback(query, cookie))
+	if !errors.Is(err, ErrInvalidOAuthState) {
+		t.Errorf("Exchange error = %v, want ErrInvalidOAuthState", err)
+	}
+}
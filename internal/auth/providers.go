@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"fmt"
+
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/azureadv2"
+	"github.com/markbates/goth/providers/github"
+	"github.com/markbates/goth/providers/google"
+	"github.com/markbates/goth/providers/openidConnect"
+)
+
+const (
+	ProviderGoogle    = "google"
+	ProviderGitHub    = "github"
+	ProviderMicrosoft = "microsoft"
+)
+
+// ProviderConfig configures an OAuth provider, providers without a client ID
+// are not registered.
+type ProviderConfig struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// TrustEmail treats every email of the provider as verified, for
+	// providers that only return verified emails without saying so.
+	TrustEmail bool
+}
+
+func (p ProviderConfig) enabled() bool {
+	return p.ClientID != ""
+}
+
+// MicrosoftConfig restricts the logins to an Entra ID tenant, a tenant ID or
+// domain, "organizations" or "common".
+type MicrosoftConfig struct {
+	ProviderConfig
+	Tenant string
+}
+
+// OIDCConfig registers a generic OpenID Connect provider found with the
+// discovery document of the issuer.
+type OIDCConfig struct {
+	ProviderConfig
+	Name         string
+	DiscoveryURL string
+}
+
+type ProvidersConfig struct {
+	// CallbackURL is the default redirect URL, "%s" is replaced by the name
+	// of the provider.
+	CallbackURL string
+	Google      ProviderConfig
+	GitHub      ProviderConfig
+	Microsoft   MicrosoftConfig
+	OIDC        OIDCConfig
+}
+
+func (cfg ProvidersConfig) redirectURL(p ProviderConfig, name string) string {
+	if p.RedirectURL != "" {
+		return p.RedirectURL
+	}
+
+	return fmt.Sprintf(cfg.CallbackURL, name)
+}
+
+// TrustsEmail reports whether the emails of the provider count as verified
+// even without a verified claim.
+func (cfg ProvidersConfig) TrustsEmail(provider string) bool {
+	switch provider {
+	case ProviderGoogle:
+		return cfg.Google.TrustEmail
+	case ProviderGitHub:
+		return cfg.GitHub.TrustEmail
+	case ProviderMicrosoft:
+		return cfg.Microsoft.TrustEmail
+	case cfg.OIDC.Name:
+		return cfg.OIDC.TrustEmail
+	default:
+		return false
+	}
+}
+
+// NewProviders builds the enabled providers, the OIDC provider fetches its
+// discovery document.
+func NewProviders(cfg ProvidersConfig) ([]goth.Provider, error) {
+	providers := []goth.Provider{}
+
+	if cfg.Google.enabled() {
+		p := cfg.Google
+		providers = append(providers, google.New(p.ClientID, p.ClientSecret, cfg.redirectURL(p, ProviderGoogle), p.Scopes...))
+	}
+
+	if cfg.GitHub.enabled() {
+		p := cfg.GitHub
+		providers = append(providers, github.New(p.ClientID, p.ClientSecret, cfg.redirectURL(p, ProviderGitHub), p.Scopes...))
+	}
+
+	if cfg.Microsoft.enabled() {
+		p := cfg.Microsoft.ProviderConfig
+
+		scopes := make([]azureadv2.ScopeType, len(p.Scopes))
+		for i, scope := range p.Scopes {
+			scopes[i] = azureadv2.ScopeType(scope)
+		}
+
+		microsoft := azureadv2.New(p.ClientID, p.ClientSecret, cfg.redirectURL(p, ProviderMicrosoft), azureadv2.ProviderOptions{
+			Tenant: azureadv2.TenantType(cfg.Microsoft.Tenant),
+			Scopes: scopes,
+		})
+		microsoft.SetName(ProviderMicrosoft)
+
+		providers = append(providers, microsoft)
+	}
+
+	if cfg.OIDC.enabled() {
+		p := cfg.OIDC.ProviderConfig
+		if cfg.OIDC.Name == "" || cfg.OIDC.DiscoveryURL == "" {
+			return nil, fmt.Errorf("the OIDC provider needs a name and a discovery URL")
+		}
+
+		oidc, err := openidConnect.NewNamed(cfg.OIDC.Name, p.ClientID, p.ClientSecret, cfg.redirectURL(p, cfg.OIDC.Name), cfg.OIDC.DiscoveryURL, p.Scopes...)
+		if err != nil {
+			return nil, fmt.Errorf("OIDC provider %s: %w", cfg.OIDC.Name, err)
+		}
+		// goth suffixes named providers with "-oidc"
+		oidc.SetName(cfg.OIDC.Name)
+
+		providers = append(providers, oidc)
+	}
+
+	return providers, nil
+}
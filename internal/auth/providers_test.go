@@ -0,0 +1,309 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/markbates/goth"
+)
+
+const (
+	stubClientID     = "client-id"
+	stubClientSecret = "client-secret"
+	stubCode         = "auth-code"
+	stubAccessToken  = "access-token"
+	stubSubject      = "user-123"
+)
+
+// stubOIDC is an OpenID Connect provider with the discovery, token and
+// userinfo endpoints, the ID token it issues is not signed.
+type stubOIDC struct {
+	*httptest.Server
+	audience string
+}
+
+func newStubOIDC(t *testing.T) *stubOIDC {
+	t.Helper()
+
+	stub := &stubOIDC{audience: stubClientID}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, map[string]any{
+			"issuer":                 stub.URL,
+			"authorization_endpoint": stub.URL + "/authorize",
+			"token_endpoint":         stub.URL + "/token",
+			"userinfo_endpoint":      stub.URL + "/userinfo",
+		})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		id, secret, ok := r.BasicAuth()
+		if !ok {
+			id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+		}
+
+		if id != stubClientID || secret != stubClientSecret {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+
+		if r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("code") != stubCode {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		writeJSON(t, w, map[string]any{
+			"access_token": stubAccessToken,
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token": unsignedJWT(t, map[string]any{
+				"iss": stub.URL,
+				"aud": stub.audience,
+				"sub": stubSubject,
+				"exp": time.Now().Add(time.Hour).Unix(),
+			}),
+		})
+	})
+
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+stubAccessToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		writeJSON(t, w, map[string]any{
+			"sub":            stubSubject,
+			"email":          "gopher@example.com",
+			"email_verified": true,
+			"name":           "Gopher",
+		})
+	})
+
+	stub.Server = httptest.NewServer(mux)
+	t.Cleanup(stub.Close)
+
+	return stub
+}
+
+func writeJSON(t *testing.T, w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		t.Errorf("encode response: %v", err)
+	}
+}
+
+func unsignedJWT(t *testing.T, claims map[string]any) string {
+	t.Helper()
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(`{"alg":"none"}`)) + "." + enc.EncodeToString(payload) + ".sig"
+}
+
+func oidcProvider(t *testing.T, stub *stubOIDC) goth.Provider {
+	t.Helper()
+
+	providers, err := NewProviders(ProvidersConfig{
+		CallbackURL: "http://localhost:8080/v1/auth/%s/callback",
+		OIDC: OIDCConfig{
+			ProviderConfig: ProviderConfig{
+				ClientID:     stubClientID,
+				ClientSecret: stubClientSecret,
+			},
+			Name:         "campus",
+			DiscoveryURL: stub.URL + "/.well-known/openid-configuration",
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewProviders: %v", err)
+	}
+
+	if len(providers) != 1 {
+		t.Fatalf("got %d providers, want only the OIDC one", len(providers))
+	}
+
+	return providers[0]
+}
+
+func TestOIDCProviderFlow(t *testing.T) {
+	stub := newStubOIDC(t)
+	provider := oidcProvider(t, stub)
+
+	if provider.Name() != "campus" {
+		t.Errorf("provider name = %q, want campus", provider.Name())
+	}
+
+	session, err := provider.BeginAuth("state-value")
+	if err != nil {
+		t.Fatalf("BeginAuth: %v", err)
+	}
+
+	authURL, err := session.GetAuthURL()
+	if err != nil {
+		t.Fatalf("GetAuthURL: %v", err)
+	}
+
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := parsed.Query()
+	if !strings.HasPrefix(authURL, stub.URL+"/authorize") {
+		t.Errorf("auth URL %s does not use the discovered endpoint", authURL)
+	}
+	if query.Get("state") != "state-value" || query.Get("client_id") != stubClientID {
+		t.Errorf("auth URL %s is missing the state or client", authURL)
+	}
+	if got := query.Get("redirect_uri"); got != "http://localhost:8080/v1/auth/campus/callback" {
+		t.Errorf("redirect_uri = %q, want the callback of the provider", got)
+	}
+
+	if _, err := session.Authorize(provider, url.Values{"code": {stubCode}}); err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+
+	user, err := provider.FetchUser(session)
+	if err != nil {
+		t.Fatalf("FetchUser: %v", err)
+	}
+
+	if user.Provider != "campus" || user.UserID != stubSubject {
+		t.Errorf("user = %s/%s, want campus/%s", user.Provider, user.UserID, stubSubject)
+	}
+
+	if user.Email != "gopher@example.com" || user.RawData["email_verified"] != true {
+		t.Errorf("userinfo claims were not merged: %+v", user.RawData)
+	}
+}
+
+func TestOIDCProviderRejectsInvalidCode(t *testing.T) {
+	stub := newStubOIDC(t)
+	provider := oidcProvider(t, stub)
+
+	session, err := provider.BeginAuth("state-value")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Authorize(provider, url.Values{"code": {"wrong"}}); err == nil {
+		t.Error("expected the token exchange to fail")
+	}
+}
+
+func TestOIDCProviderRejectsOtherAudience(t *testing.T) {
+	stub := newStubOIDC(t)
+	stub.audience = "another-client"
+	provider := oidcProvider(t, stub)
+
+	session, err := provider.BeginAuth("state-value")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Authorize(provider, url.Values{"code": {stubCode}}); err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+
+	if _, err := provider.FetchUser(session); err == nil {
+		t.Error("expected an ID token for another client to be rejected")
+	}
+}
+
+func TestNewProviders(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     ProvidersConfig
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "disabled providers are skipped",
+			cfg:  ProvidersConfig{CallbackURL: "http://localhost/%s"},
+			want: []string{},
+		},
+		{
+			name: "enabled providers keep their names",
+			cfg: ProvidersConfig{
+				CallbackURL: "http://localhost/%s",
+				Google:      ProviderConfig{ClientID: "google"},
+				GitHub:      ProviderConfig{ClientID: "github"},
+				Microsoft:   MicrosoftConfig{ProviderConfig: ProviderConfig{ClientID: "microsoft"}, Tenant: "common"},
+			},
+			want: []string{ProviderGoogle, ProviderGitHub, ProviderMicrosoft},
+		},
+		{
+			name:    "OIDC needs a discovery URL",
+			cfg:     ProvidersConfig{OIDC: OIDCConfig{ProviderConfig: ProviderConfig{ClientID: "oidc"}, Name: "campus"}},
+			wantErr: true,
+		},
+		{
+			name: "OIDC discovery must be reachable",
+			cfg: ProvidersConfig{OIDC: OIDCConfig{
+				ProviderConfig: ProviderConfig{ClientID: "oidc"},
+				Name:           "campus",
+				DiscoveryURL:   "http://127.0.0.1:1/.well-known/openid-configuration",
+			}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			providers, err := NewProviders(tt.cfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewProviders: %v", err)
+			}
+
+			got := []string{}
+			for _, p := range providers {
+				got = append(got, p.Name())
+			}
+
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("providers = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrustsEmail(t *testing.T) {
+	cfg := ProvidersConfig{
+		Google: ProviderConfig{TrustEmail: true},
+		OIDC:   OIDCConfig{ProviderConfig: ProviderConfig{TrustEmail: true}, Name: "campus"},
+	}
+
+	for provider, want := range map[string]bool{
+		ProviderGoogle:    true,
+		ProviderGitHub:    false,
+		ProviderMicrosoft: false,
+		"campus":          true,
+		"unknown":         false,
+	} {
+		if got := cfg.TrustsEmail(provider); got != want {
+			t.Errorf("TrustsEmail(%q) = %v, want %v", provider, got, want)
+		}
+	}
+}
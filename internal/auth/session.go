@@ -1,36 +1,21 @@
 package auth
 
 import (
-	"net/http"
-	"os"
-
 	"github.com/gorilla/sessions"
 	"github.com/markbates/goth"
 	"github.com/markbates/goth/gothic"
-	"github.com/markbates/goth/providers/google"
-)
-
-const (
-	maxAge = 86400 * 30
-	isProd = false
 )
 
-func NewAuth() {
-	googleClientID := os.Getenv("GOOGLE_CLIENT_ID")
-	googleClientSecret := os.Getenv("GOOGLE_CLIENT_SECRET")
-	googleRedirectURL := os.Getenv("GOOGLE_REDIRECT_URL")
-
-	store := sessions.NewCookieStore([]byte(os.Getenv("SESSION_SECRET")))
-	store.MaxAge(maxAge)
-	store.Options.Path = "/"
-	// TODO: CAMBIAR A TRUE EN PRODUCCION
-	store.Options.HttpOnly = false
-	store.Options.Secure = isProd
-	store.Options.SameSite = http.SameSiteLaxMode
+// NewAuth registers the providers and the store of the login sessions.
+func NewAuth(cfg ProvidersConfig, store sessions.Store) error {
+	providers, err := NewProviders(cfg)
+	if err != nil {
+		return err
+	}
 
 	gothic.Store = store
 
-	goth.UseProviders(
-		google.New(googleClientID, googleClientSecret, googleRedirectURL, "email", "profile"),
-	)
+	goth.UseProviders(providers...)
+
+	return nil
 }
@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of the RFC 6238 test vectors.
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+// The RFC lists 8 digit codes, the 6 digit codes are their last 6 digits.
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{unix: 59, code: "287082"},
+	{unix: 1111111109, code: "081804"},
+	{unix: 1111111111, code: "050471"},
+	{unix: 1234567890, code: "005924"},
+	{unix: 2000000000, code: "279037"},
+	{unix: 20000000000, code: "353130"},
+}
+
+func TestTOTPCode(t *testing.T) {
+	for _, tt := range rfcVectors {
+		got, err := TOTPCode(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("TOTPCode: %v", err)
+		}
+
+		if got != tt.code {
+			t.Errorf("TOTPCode at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestTOTPCodeLowercaseSecret(t *testing.T) {
+	got, err := TOTPCode(strings.ToLower(rfcSecret), time.Unix(59, 0))
+	if err != nil {
+		t.Fatalf("TOTPCode: %v", err)
+	}
+
+	if got != "287082" {
+		t.Errorf("TOTPCode = %s, want 287082", got)
+	}
+}
+
+func TestValidateTOTPVectors(t *testing.T) {
+	for _, tt := range rfcVectors {
+		at := time.Unix(tt.unix, 0)
+
+		step, ok := ValidateTOTP(rfcSecret, tt.code, at)
+		if !ok {
+			t.Errorf("ValidateTOTP rejected %s at %d", tt.code, tt.unix)
+			continue
+		}
+
+		if want := tt.unix / totpPeriod; step != want {
+			t.Errorf("ValidateTOTP step = %d, want %d", step, want)
+		}
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name   string
+		offset int64
+		valid  bool
+	}{
+		{name: "current step", offset: 0, valid: true},
+		{name: "one step behind", offset: -1, valid: true},
+		{name: "one step ahead", offset: 1, valid: true},
+		{name: "two steps behind", offset: -2, valid: false},
+		{name: "two steps ahead", offset: 2, valid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the code the authenticator shows with its clock off by offset steps
+			code, err := TOTPCode(rfcSecret, now.Add(time.Duration(tt.offset*totpPeriod)*time.Second))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			step, ok := ValidateTOTP(rfcSecret, code, now)
+			if ok != tt.valid {
+				t.Fatalf("ValidateTOTP = %v, want %v", ok, tt.valid)
+			}
+
+			if ok && step != current+tt.offset {
+				t.Errorf("step = %d, want %d", step, current+tt.offset)
+			}
+		})
+	}
+}
+
+// A code keeps validating while it is within the skew window, callers reject
+// it the second time because its step was already used.
+func TestValidateTOTPReuseReturnsSameStep(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+
+	code, err := TOTPCode(rfcSecret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, ok := ValidateTOTP(rfcSecret, code, now)
+	if !ok {
+		t.Fatal("first use rejected")
+	}
+
+	second, ok := ValidateTOTP(rfcSecret, code, now.Add(totpPeriod*time.Second))
+	if !ok {
+		t.Fatal("code rejected within the skew window")
+	}
+
+	if first != second {
+		t.Errorf("reused code returned step %d, then %d", first, second)
+	}
+}
+
+func TestValidateTOTPRejectsMalformed(t *testing.T) {
+	now := time.Unix(59, 0)
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		valid  bool
+	}{
+		{name: "surrounding spaces", secret: rfcSecret, code: " 287082 ", valid: true},
+		{name: "wrong code", secret: rfcSecret, code: "287083", valid: false},
+		{name: "too short", secret: rfcSecret, code: "28708", valid: false},
+		{name: "8 digit code", secret: rfcSecret, code: "94287082", valid: false},
+		{name: "empty", secret: rfcSecret, code: "", valid: false},
+		{name: "invalid secret", secret: "not base32!", code: "287082", valid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := ValidateTOTP(tt.secret, tt.code, now); ok != tt.valid {
+				t.Errorf("ValidateTOTP(%q) = %v, want %v", tt.code, ok, tt.valid)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("secret %q is not base32: %v", secret, err)
+	}
+
+	if len(key) != 20 {
+		t.Errorf("secret has %d bytes, want 20", len(key))
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("Notes App", "gopher@example.com", rfcSecret)
+
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.Scheme != "otpauth" || parsed.Host != "totp" {
+		t.Errorf("URI %s is not an otpauth totp URI", uri)
+	}
+
+	if parsed.Path != "/Notes App:gopher@example.com" {
+		t.Errorf("label = %q", parsed.Path)
+	}
+
+	query := parsed.Query()
+	if query.Get("secret") != rfcSecret || query.Get("issuer") != "Notes App" || query.Get("digits") != "6" || query.Get("period") != "30" {
+		t.Errorf("unexpected parameters %v", query)
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	want := HashRecoveryCode("abcde-12345")
+
+	for _, code := range []string{
+		"abcde-12345",
+		"ABCDE-12345",
+		"abcde12345",
+		"AbCdE 12345",
+		" abcde-12345 ",
+		"ab-cde-123-45",
+	} {
+		if got := HashRecoveryCode(code); got != want {
+			t.Errorf("HashRecoveryCode(%q) doesn't match the code", code)
+		}
+	}
+
+	for _, code := range []string{"abcde-12346", "abcde_12345", "abcde-1234"} {
+		if HashRecoveryCode(code) == want {
+			t.Errorf("HashRecoveryCode(%q) matches another code", code)
+		}
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(codes) != recoveryCodesCount {
+		t.Fatalf("got %d codes, want %d", len(codes), recoveryCodesCount)
+	}
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("code %q is not formatted as xxxxx-xxxxx", code)
+		}
+
+		if seen[code] {
+			t.Errorf("duplicate code %q", code)
+		}
+		seen[code] = true
+	}
+}
@@ -0,0 +1,65 @@
+package mail
+
+import (
+	"bytes"
+
+	gomail "gopkg.in/mail.v2"
+)
+
+// newGomailMessage builds the MIME message sent by the SMTP based clients.
+func newGomailMessage(fromEmail string, msg *Message) *gomail.Message {
+	m := gomail.NewMessage()
+
+	from := msg.sender(fromEmail)
+	m.SetAddressHeader("From", from.Email, from.Name)
+
+	setAddresses(m, "To", msg.To)
+	setAddresses(m, "Cc", msg.Cc)
+	setAddresses(m, "Bcc", msg.Bcc)
+
+	if msg.ReplyTo != nil {
+		m.SetAddressHeader("Reply-To", msg.ReplyTo.Email, msg.ReplyTo.Name)
+	}
+
+	m.SetHeader("Subject", msg.Subject)
+
+	for k, v := range msg.headers() {
+		m.SetHeader(k, v)
+	}
+
+	switch {
+	case msg.Text != "" && msg.HTML != "":
+		m.SetBody("text/plain", msg.Text)
+		m.AddAlternative("text/html", msg.HTML)
+	case msg.HTML != "":
+		m.SetBody("text/html", msg.HTML)
+	default:
+		m.SetBody("text/plain", msg.Text)
+	}
+
+	for _, a := range msg.Attachments {
+		settings := []gomail.FileSetting{}
+		if a.ContentType != "" {
+			settings = append(settings, gomail.SetHeader(map[string][]string{
+				"Content-Type": {a.ContentType},
+			}))
+		}
+
+		m.AttachReader(a.Filename, bytes.NewReader(a.Content), settings...)
+	}
+
+	return m
+}
+
+func setAddresses(m *gomail.Message, field string, addresses []Address) {
+	if len(addresses) == 0 {
+		return
+	}
+
+	values := make([]string, len(addresses))
+	for i, a := range addresses {
+		values[i] = m.FormatAddress(a.Email, a.Name)
+	}
+
+	m.SetHeader(field, values...)
+}
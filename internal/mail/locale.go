@@ -0,0 +1,50 @@
+package mail
+
+import (
+	"golang.org/x/text/language"
+)
+
+// DefaultLocale is used when the user locale has no translation.
+const DefaultLocale = "en"
+
+// SupportedLocales are the locales with a templates directory, the first one
+// is the default.
+var SupportedLocales = []string{DefaultLocale, "es"}
+
+var localeMatcher = language.NewMatcher([]language.Tag{
+	language.English,
+	language.Spanish,
+})
+
+// MatchLocale picks the supported locale that best fits an Accept-Language
+// header, falling back to DefaultLocale.
+func MatchLocale(acceptLanguage string) string {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return DefaultLocale
+	}
+
+	_, index, confidence := localeMatcher.Match(tags...)
+	if confidence == language.No {
+		return DefaultLocale
+	}
+
+	return SupportedLocales[index]
+}
+
+// localeFallbacks returns the locales to try in order for a locale, e.g.
+// es-MX, es and then the default locale.
+func localeFallbacks(locale string) []string {
+	locales := []string{}
+	if locale != "" {
+		locales = append(locales, locale)
+	}
+
+	if tag, err := language.Parse(locale); err == nil {
+		if base, confidence := tag.Base(); confidence != language.No && base.String() != locale {
+			locales = append(locales, base.String())
+		}
+	}
+
+	return append(locales, DefaultLocale)
+}
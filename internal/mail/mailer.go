@@ -1,16 +1,68 @@
 package mail
 
-import "embed"
+import (
+	"embed"
+	"fmt"
+)
 
 const (
 	FromName            = "GopherSocial"
-	maxRetries          = 3
 	UserWelcomeTemplate = "user_invitation.tmpl"
+
+	EmailChangeConfirmTemplate = "email_change_confirm.tmpl"
+	EmailChangeNoticeTemplate  = "email_change_notice.tmpl"
+	NoteCommentTemplate        = "note_comment.tmpl"
+	AccountLockedTemplate      = "account_locked.tmpl"
+	MagicLinkTemplate          = "magic_link.tmpl"
+	AccountSuspendedTemplate   = "account_suspended.tmpl"
 )
 
 //go:embed "templates"
 var FS embed.FS
 
+const (
+	ProviderSMTP     = "smtp"
+	ProviderSendGrid = "sendgrid"
+	ProviderResend   = "resend"
+	ProviderMailtrap = "mailtrap"
+	ProviderSink     = "sink"
+)
+
+// Client delivers a message in a single attempt, the outbox dispatcher
+// retries the failed ones with backoff.
 type Client interface {
-	Send(templateFile, username, email string, data any, isSandbox bool) (int, error)
+	Send(msg *Message, isSandbox bool) (int, error)
+}
+
+// Config holds the settings of every provider, only the ones of the selected
+// provider are used.
+type Config struct {
+	Provider       string
+	FromEmail      string
+	SendGridAPIKey string
+	ResendAPIKey   string
+	MailtrapAPIKey string
+	SMTP           SMTPConfig
+	SinkDir        string
+}
+
+// New returns the client of the configured provider.
+func New(cfg Config) (Client, error) {
+	switch cfg.Provider {
+	case ProviderSMTP:
+		return NewSMTPClient(cfg.SMTP, cfg.FromEmail)
+	case ProviderSendGrid:
+		if cfg.SendGridAPIKey == "" {
+			return nil, fmt.Errorf("sendgrid api key is required")
+		}
+		return NewSendgrid(cfg.SendGridAPIKey, cfg.FromEmail), nil
+	case ProviderResend:
+		return NewResendClient(cfg.ResendAPIKey, cfg.FromEmail)
+	case ProviderMailtrap:
+		return NewMailTrapClient(cfg.MailtrapAPIKey, cfg.FromEmail)
+	case ProviderSink:
+		return NewSinkClient(cfg.SinkDir, cfg.FromEmail)
+	default:
+		return nil, fmt.Errorf("unknown mail provider %q", cfg.Provider)
+	}
 }
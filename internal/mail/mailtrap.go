@@ -1,12 +1,8 @@
 package mail
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
-	"time"
-
-	"text/template"
 
 	gomail "gopkg.in/mail.v2"
 )
@@ -27,43 +23,18 @@ func NewMailTrapClient(apiKey, fromEmail string) (mailtrapClient, error) {
 	}, nil
 }
 
-func (m mailtrapClient) Send(templateFile, username, email string, data any, isSandbox bool) (int, error) {
-	// Template parsing and building
-	tmpl, err := template.ParseFS(FS, "templates/"+templateFile)
-	if err != nil {
+func (m mailtrapClient) Send(msg *Message, isSandbox bool) (int, error) {
+	if err := msg.validate(); err != nil {
 		return -1, err
 	}
 
-	subject := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(subject, "subject", data)
-	if err != nil {
-		return -1, err
-	}
-
-	body := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(body, "body", data)
-	if err != nil {
-		return -1, err
-	}
-
-	message := gomail.NewMessage()
-	message.SetHeader("From", m.fromEmail)
-	message.SetHeader("To", email)
-	message.SetHeader("Subject", subject.String())
-
-	message.AddAlternative("text/html", body.String())
+	message := newGomailMessage(m.fromEmail, msg)
 
 	dialer := gomail.NewDialer("live.smtp.mailtrap.io", 587, "api", m.apiKey)
 
-	var retryErr error
-
-	for i := 0; i < maxRetries; i++ {
-		if retryErr = dialer.DialAndSend(message); retryErr != nil {
-			time.Sleep(time.Second * time.Duration(i*2))
-			continue
-		}
-		return 200, nil
+	if err := dialer.DialAndSend(message); err != nil {
+		return -1, fmt.Errorf("could not send email: %w", err)
 	}
 
-	return -1, fmt.Errorf("could not send email after %d retries: %w", maxRetries, retryErr)
+	return 200, nil
 }
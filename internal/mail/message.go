@@ -0,0 +1,80 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+)
+
+type Address struct {
+	Name  string
+	Email string
+}
+
+func (a Address) String() string {
+	return (&mail.Address{Name: a.Name, Address: a.Email}).String()
+}
+
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Content     []byte
+}
+
+// Message is an email ready to be delivered by any Client. Text is sent as
+// the plain text alternative of HTML when both are set.
+type Message struct {
+	From        *Address
+	To          []Address
+	Cc          []Address
+	Bcc         []Address
+	ReplyTo     *Address
+	Subject     string
+	Text        string
+	HTML        string
+	Attachments []Attachment
+	Headers     map[string]string
+
+	// UnsubscribeURL is sent in the List-Unsubscribe header, with one-click
+	// unsubscribe (RFC 8058) support.
+	UnsubscribeURL string
+}
+
+var ErrNoRecipients = errors.New("email has no recipients")
+
+// headers returns the custom headers of the message including the
+// List-Unsubscribe ones.
+func (m *Message) headers() map[string]string {
+	headers := make(map[string]string, len(m.Headers)+2)
+	for k, v := range m.Headers {
+		headers[k] = v
+	}
+
+	if m.UnsubscribeURL != "" {
+		headers["List-Unsubscribe"] = fmt.Sprintf("<%s>", m.UnsubscribeURL)
+		headers["List-Unsubscribe-Post"] = "List-Unsubscribe=One-Click"
+	}
+
+	return headers
+}
+
+// sender returns the From of the message or the default one of the client.
+func (m *Message) sender(fromEmail string) Address {
+	if m.From != nil {
+		return *m.From
+	}
+
+	return Address{Name: FromName, Email: fromEmail}
+}
+
+func (m *Message) validate() error {
+	if len(m.To)+len(m.Cc)+len(m.Bcc) == 0 {
+		return ErrNoRecipients
+	}
+
+	if m.Text == "" && m.HTML == "" {
+		return errors.New("email has no body")
+	}
+
+	return nil
+}
@@ -0,0 +1,365 @@
+package mail
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testMessage() *Message {
+	return &Message{
+		To:             []Address{{Name: "Gopher", Email: "gopher@example.com"}},
+		Cc:             []Address{{Email: "cc@example.com"}},
+		Bcc:            []Address{{Email: "bcc@example.com"}},
+		ReplyTo:        &Address{Name: "Support", Email: "support@example.com"},
+		Subject:        "New comment",
+		Text:           "plain body",
+		HTML:           "<p>html body</p>",
+		Attachments:    []Attachment{{Filename: "notes.pdf", ContentType: "application/pdf", Content: []byte("%PDF")}},
+		Headers:        map[string]string{"X-Note-ID": "42"},
+		UnsubscribeURL: "https://example.com/v1/notifications/unsubscribe/token",
+	}
+}
+
+// stubAPI answers every request with the status and counts the requests,
+// the body of the last one is decoded into body.
+type stubAPI struct {
+	*httptest.Server
+	requests atomic.Int32
+	body     map[string]any
+}
+
+func newStubAPI(t *testing.T, status int, response string) *stubAPI {
+	t.Helper()
+
+	stub := &stubAPI{}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stub.requests.Add(1)
+
+		raw, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("read request: %v", err)
+		}
+
+		stub.body = map[string]any{}
+		if err := json.Unmarshal(raw, &stub.body); err != nil {
+			t.Errorf("request body is not JSON: %v", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(stub.Close)
+
+	return stub
+}
+
+func newTestSendGrid(stub *stubAPI) *SendGridMailer {
+	m := NewSendgrid("sendgrid-key", "noreply@example.com")
+	m.client.BaseURL = stub.URL + "/v3/mail/send"
+	return m
+}
+
+func TestSendGridSend(t *testing.T) {
+	stub := newStubAPI(t, http.StatusAccepted, "")
+	m := newTestSendGrid(stub)
+
+	status, err := m.Send(testMessage(), true)
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	if status != http.StatusAccepted {
+		t.Errorf("status = %d, want %d", status, http.StatusAccepted)
+	}
+
+	raw, _ := json.Marshal(stub.body)
+	body := string(raw)
+
+	for _, want := range []string{
+		`"to":[{"email":"gopher@example.com","name":"Gopher"}]`,
+		`"cc":[{"email":"cc@example.com"}]`,
+		`"bcc":[{"email":"bcc@example.com"}]`,
+		`"reply_to":{"email":"support@example.com","name":"Support"}`,
+		`"content":[{"type":"text/plain","value":"plain body"},{"type":"text/html","value":"\u003cp\u003ehtml body\u003c/p\u003e"}]`,
+		`"List-Unsubscribe":"\u003chttps://example.com/v1/notifications/unsubscribe/token\u003e"`,
+		`"List-Unsubscribe-Post":"List-Unsubscribe=One-Click"`,
+		`"X-Note-ID":"42"`,
+		`"filename":"notes.pdf"`,
+		`"sandbox_mode":{"enable":true}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("request is missing %s:\n%s", want, body)
+		}
+	}
+}
+
+func TestSendGridSendReturnsAPIErrors(t *testing.T) {
+	stub := newStubAPI(t, http.StatusInternalServerError, `{"errors":[{"message":"unavailable"}]}`)
+	m := newTestSendGrid(stub)
+
+	if _, err := m.Send(testMessage(), false); err == nil {
+		t.Fatal("expected an error for a failed request")
+	}
+
+	if n := stub.requests.Load(); n != 1 {
+		t.Errorf("sent %d requests, want 1, the outbox retries", n)
+	}
+}
+
+func newTestResend(t *testing.T, stub *stubAPI) *resendClient {
+	t.Helper()
+
+	m, err := NewResendClient("resend-key", "noreply@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.client.BaseURL, err = url.Parse(stub.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestResendSend(t *testing.T) {
+	stub := newStubAPI(t, http.StatusOK, `{"id":"email-id"}`)
+	m := newTestResend(t, stub)
+
+	if _, err := m.Send(testMessage(), false); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	body := stub.body
+	if body["from"] != `"`+FromName+`" <noreply@example.com>` {
+		t.Errorf("from = %v", body["from"])
+	}
+
+	for field, want := range map[string]string{
+		"to":  `"Gopher" <gopher@example.com>`,
+		"cc":  `<cc@example.com>`,
+		"bcc": `<bcc@example.com>`,
+	} {
+		got, _ := body[field].([]any)
+		if len(got) != 1 || got[0] != want {
+			t.Errorf("%s = %v, want [%s]", field, body[field], want)
+		}
+	}
+
+	if body["text"] != "plain body" || body["html"] != "<p>html body</p>" {
+		t.Errorf("bodies = %v / %v", body["text"], body["html"])
+	}
+
+	headers, _ := body["headers"].(map[string]any)
+	if headers["List-Unsubscribe-Post"] != "List-Unsubscribe=One-Click" || headers["X-Note-ID"] != "42" {
+		t.Errorf("headers = %v", headers)
+	}
+
+	attachments, _ := body["attachments"].([]any)
+	if len(attachments) != 1 {
+		t.Fatalf("attachments = %v", body["attachments"])
+	}
+}
+
+func TestResendSendReturnsAPIErrors(t *testing.T) {
+	stub := newStubAPI(t, http.StatusInternalServerError, `{"name":"internal_server_error","message":"unavailable"}`)
+	m := newTestResend(t, stub)
+
+	if _, err := m.Send(testMessage(), false); err == nil {
+		t.Fatal("expected an error for a failed request")
+	}
+
+	if n := stub.requests.Load(); n != 1 {
+		t.Errorf("sent %d requests, want 1, the outbox retries", n)
+	}
+}
+
+func TestSMTPSendFailsWithoutRetry(t *testing.T) {
+	// a port nobody listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().(*net.TCPAddr)
+	l.Close()
+
+	m, err := NewSMTPClient(SMTPConfig{Host: "127.0.0.1", Port: addr.Port, TLS: SMTPTLSNone}, "noreply@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if _, err := m.Send(testMessage(), false); err == nil {
+		t.Fatal("expected an error without a server")
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Send took %s, it must fail right away and leave the retries to the outbox", elapsed)
+	}
+}
+
+func TestNewSMTPClient(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     SMTPConfig
+		wantErr bool
+	}{
+		{name: "starttls by default", cfg: SMTPConfig{Host: "smtp.example.com", Port: 587}},
+		{name: "implicit tls", cfg: SMTPConfig{Host: "smtp.example.com", Port: 465, TLS: SMTPTLSImplicit}},
+		{name: "no tls", cfg: SMTPConfig{Host: "localhost", Port: 1025, TLS: SMTPTLSNone}},
+		{name: "missing host", cfg: SMTPConfig{Port: 587}, wantErr: true},
+		{name: "unknown tls mode", cfg: SMTPConfig{Host: "smtp.example.com", TLS: "ssl3"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSMTPClient(tt.cfg, "noreply@example.com")
+			if tt.wantErr != (err != nil) {
+				t.Errorf("NewSMTPClient error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSinkSend(t *testing.T) {
+	dir := t.TempDir()
+
+	m, err := NewSinkClient(dir, "noreply@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Send(testMessage(), true); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*_gopher_example.com.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("sink files = %v, %v", files, err)
+	}
+
+	raw, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	eml := string(raw)
+
+	for _, want := range []string{
+		`From: "` + FromName + `" <noreply@example.com>`,
+		`To: "Gopher" <gopher@example.com>`,
+		`Cc: cc@example.com`,
+		`Reply-To: "Support" <support@example.com>`,
+		`Subject: New comment`,
+		`List-Unsubscribe: <https://example.com/v1/notifications/unsubscribe/token>`,
+		`List-Unsubscribe-Post: List-Unsubscribe=One-Click`,
+		`X-Note-ID: 42`,
+		`multipart/alternative`,
+		`Content-Type: text/plain`,
+		`Content-Type: text/html`,
+		`Content-Disposition: attachment; filename="notes.pdf"`,
+	} {
+		if !strings.Contains(eml, want) {
+			t.Errorf("message is missing %q:\n%s", want, eml)
+		}
+	}
+
+	// the plain text alternative comes first
+	if strings.Index(eml, "text/plain") > strings.Index(eml, "text/html") {
+		t.Error("the text part must come before the HTML one")
+	}
+}
+
+func TestSendValidatesMessage(t *testing.T) {
+	sink, err := NewSinkClient(t.TempDir(), "noreply@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	smtp, err := NewSMTPClient(SMTPConfig{Host: "localhost", Port: 1025, TLS: SMTPTLSNone}, "noreply@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stub := newStubAPI(t, http.StatusAccepted, "")
+
+	clients := map[string]Client{
+		ProviderSink:     sink,
+		ProviderSMTP:     smtp,
+		ProviderSendGrid: newTestSendGrid(stub),
+		ProviderResend:   newTestResend(t, stub),
+	}
+
+	for name, client := range clients {
+		if _, err := client.Send(&Message{Subject: "no one", Text: "body"}, true); !errors.Is(err, ErrNoRecipients) {
+			t.Errorf("%s: error = %v, want ErrNoRecipients", name, err)
+		}
+
+		empty := &Message{To: []Address{{Email: "gopher@example.com"}}, Subject: "empty"}
+		if _, err := client.Send(empty, true); err == nil {
+			t.Errorf("%s: expected an error for a message without body", name)
+		}
+	}
+
+	if n := stub.requests.Load(); n != 0 {
+		t.Errorf("invalid messages sent %d requests", n)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{name: "smtp", cfg: Config{Provider: ProviderSMTP, SMTP: SMTPConfig{Host: "localhost", Port: 1025, TLS: SMTPTLSNone}}},
+		{name: "sendgrid", cfg: Config{Provider: ProviderSendGrid, SendGridAPIKey: "key"}},
+		{name: "sendgrid without key", cfg: Config{Provider: ProviderSendGrid}, wantErr: true},
+		{name: "resend", cfg: Config{Provider: ProviderResend, ResendAPIKey: "key"}},
+		{name: "resend without key", cfg: Config{Provider: ProviderResend}, wantErr: true},
+		{name: "mailtrap", cfg: Config{Provider: ProviderMailtrap, MailtrapAPIKey: "key"}},
+		{name: "mailtrap without key", cfg: Config{Provider: ProviderMailtrap}, wantErr: true},
+		{name: "sink", cfg: Config{Provider: ProviderSink, SinkDir: filepath.Join(t.TempDir(), "mail")}},
+		{name: "unknown provider", cfg: Config{Provider: "pigeon"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := New(tt.cfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+
+			if err != nil || client == nil {
+				t.Errorf("New: %v", err)
+			}
+		})
+	}
+}
+
+// the sink names the files after the time and the first recipient
+func TestSinkFileName(t *testing.T) {
+	for input, want := range map[string]string{
+		"gopher@example.com": "gopher_example.com",
+		"../../etc/passwd":   ".._.._etc_passwd",
+		"a b+c@example.com":  "a_b_c_example.com",
+	} {
+		if got := sanitizeFileName(input); got != want {
+			t.Errorf("sanitizeFileName(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
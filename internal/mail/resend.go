@@ -0,0 +1,68 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/resend/resend-go/v2"
+)
+
+type resendClient struct {
+	fromEmail string
+	client    *resend.Client
+}
+
+func NewResendClient(apiKey, fromEmail string) (*resendClient, error) {
+	if apiKey == "" {
+		return nil, errors.New("resend api key is required")
+	}
+
+	return &resendClient{
+		fromEmail: fromEmail,
+		client:    resend.NewClient(apiKey),
+	}, nil
+}
+
+func (m *resendClient) Send(msg *Message, isSandbox bool) (int, error) {
+	if err := msg.validate(); err != nil {
+		return -1, err
+	}
+
+	params := &resend.SendEmailRequest{
+		From:    msg.sender(m.fromEmail).String(),
+		To:      addressList(msg.To),
+		Cc:      addressList(msg.Cc),
+		Bcc:     addressList(msg.Bcc),
+		Subject: msg.Subject,
+		Html:    msg.HTML,
+		Text:    msg.Text,
+		Headers: msg.headers(),
+	}
+
+	if msg.ReplyTo != nil {
+		params.ReplyTo = msg.ReplyTo.String()
+	}
+
+	for _, a := range msg.Attachments {
+		params.Attachments = append(params.Attachments, &resend.Attachment{
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			Content:     a.Content,
+		})
+	}
+
+	if _, err := m.client.Emails.Send(params); err != nil {
+		return -1, fmt.Errorf("could not send email: %w", err)
+	}
+
+	return 200, nil
+}
+
+func addressList(addresses []Address) []string {
+	list := make([]string, len(addresses))
+	for i, a := range addresses {
+		list[i] = a.String()
+	}
+
+	return list
+}
@@ -1,10 +1,9 @@
 package mail
 
 import (
-	"bytes"
+	"encoding/base64"
 	"fmt"
-	"text/template"
-	"time"
+	"net/http"
 
 	"github.com/sendgrid/sendgrid-go"
 	"github.com/sendgrid/sendgrid-go/helpers/mail"
@@ -26,29 +25,49 @@ func NewSendgrid(apiKey, fromEmail string) *SendGridMailer {
 	}
 }
 
-func (m *SendGridMailer) Send(templateFile, username, email string, data any, isSandbox bool) (int, error) {
-	from := mail.NewEmail(FromName, m.fromEmail)
-	to := mail.NewEmail(username, email)
-
-	// template parsing and building
-	tmpl, err := template.ParseFS(FS, "templates/"+templateFile)
-	if err != nil {
+func (m *SendGridMailer) Send(msg *Message, isSandbox bool) (int, error) {
+	if err := msg.validate(); err != nil {
 		return -1, err
 	}
 
-	subject := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(subject, "subject", data)
-	if err != nil {
-		return -1, err
+	from := msg.sender(m.fromEmail)
+
+	message := mail.NewV3Mail()
+	message.SetFrom(mail.NewEmail(from.Name, from.Email))
+	message.Subject = msg.Subject
+
+	p := mail.NewPersonalization()
+	p.AddTos(sendgridEmails(msg.To)...)
+	p.AddCCs(sendgridEmails(msg.Cc)...)
+	p.AddBCCs(sendgridEmails(msg.Bcc)...)
+	message.AddPersonalizations(p)
+
+	if msg.ReplyTo != nil {
+		message.SetReplyTo(mail.NewEmail(msg.ReplyTo.Name, msg.ReplyTo.Email))
 	}
 
-	body := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(body, "body", data)
-	if err != nil {
-		return -1, err
+	// sendgrid requires text/plain to come before text/html
+	if msg.Text != "" {
+		message.AddContent(mail.NewContent("text/plain", msg.Text))
+	}
+	if msg.HTML != "" {
+		message.AddContent(mail.NewContent("text/html", msg.HTML))
 	}
 
-	message := mail.NewSingleEmail(from, subject.String(), to, "", body.String())
+	for k, v := range msg.headers() {
+		message.SetHeader(k, v)
+	}
+
+	for _, a := range msg.Attachments {
+		attachment := mail.NewAttachment().
+			SetFilename(a.Filename).
+			SetContent(base64.StdEncoding.EncodeToString(a.Content)).
+			SetDisposition("attachment")
+		if a.ContentType != "" {
+			attachment.SetType(a.ContentType)
+		}
+		message.AddAttachment(attachment)
+	}
 
 	message.SetMailSettings(&mail.MailSettings{
 		SandboxMode: &mail.Setting{
@@ -56,17 +75,24 @@ func (m *SendGridMailer) Send(templateFile, username, email string, data any, is
 		},
 	})
 
-	var retryErr error
-	for i := 0; i < maxRetries; i++ {
-		response, retryErr := m.client.Send(message)
-		if retryErr != nil {
-			// exponential backoff
-			time.Sleep(time.Second * time.Duration(i+1))
-			continue
-		}
+	response, err := m.client.Send(message)
+	if err != nil {
+		return -1, fmt.Errorf("could not send email: %w", err)
+	}
+
+	// the API errors are responses, not errors of the client
+	if response.StatusCode >= http.StatusMultipleChoices {
+		return response.StatusCode, fmt.Errorf("could not send email: sendgrid returned %d: %s", response.StatusCode, response.Body)
+	}
+
+	return response.StatusCode, nil
+}
 
-		return response.StatusCode, nil
+func sendgridEmails(addresses []Address) []*mail.Email {
+	emails := make([]*mail.Email, len(addresses))
+	for i, a := range addresses {
+		emails[i] = mail.NewEmail(a.Name, a.Email)
 	}
 
-	return -1, fmt.Errorf("failed to send email after %d attempt, error: %v", maxRetries, retryErr)
+	return emails
 }
@@ -0,0 +1,75 @@
+package mail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sinkClient writes the rendered emails to a directory as .eml files instead
+// of sending them, it is meant for development.
+type sinkClient struct {
+	fromEmail string
+	dir       string
+}
+
+func NewSinkClient(dir, fromEmail string) (*sinkClient, error) {
+	if dir == "" {
+		dir = "tmp/mail"
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &sinkClient{
+		fromEmail: fromEmail,
+		dir:       dir,
+	}, nil
+}
+
+func (m *sinkClient) Send(msg *Message, isSandbox bool) (int, error) {
+	if err := msg.validate(); err != nil {
+		return -1, err
+	}
+
+	message := newGomailMessage(m.fromEmail, msg)
+	message.SetDateHeader("Date", time.Now())
+
+	name := fmt.Sprintf("%d_%s.eml", time.Now().UnixNano(), sanitizeFileName(firstRecipient(msg)))
+
+	f, err := os.Create(filepath.Join(m.dir, name))
+	if err != nil {
+		return -1, err
+	}
+	defer f.Close()
+
+	if _, err := message.WriteTo(f); err != nil {
+		return -1, err
+	}
+
+	return 200, nil
+}
+
+func firstRecipient(msg *Message) string {
+	for _, addresses := range [][]Address{msg.To, msg.Cc, msg.Bcc} {
+		if len(addresses) > 0 {
+			return addresses[0].Email
+		}
+	}
+
+	return ""
+}
+
+func sanitizeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
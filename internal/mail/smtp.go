@@ -0,0 +1,68 @@
+package mail
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+
+	gomail "gopkg.in/mail.v2"
+)
+
+const (
+	SMTPTLSNone     = "none"
+	SMTPTLSStartTLS = "starttls"
+	SMTPTLSImplicit = "tls"
+)
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// TLS is one of none, starttls or tls (implicit TLS, usually port 465).
+	TLS string
+}
+
+type smtpClient struct {
+	fromEmail string
+	dialer    *gomail.Dialer
+}
+
+func NewSMTPClient(cfg SMTPConfig, fromEmail string) (*smtpClient, error) {
+	if cfg.Host == "" {
+		return nil, errors.New("smtp host is required")
+	}
+
+	dialer := gomail.NewDialer(cfg.Host, cfg.Port, cfg.Username, cfg.Password)
+
+	switch cfg.TLS {
+	case SMTPTLSNone:
+		dialer.StartTLSPolicy = gomail.NoStartTLS
+	case SMTPTLSStartTLS, "":
+		dialer.StartTLSPolicy = gomail.MandatoryStartTLS
+	case SMTPTLSImplicit:
+		dialer.SSL = true
+	default:
+		return nil, fmt.Errorf("unknown smtp tls mode %q", cfg.TLS)
+	}
+	dialer.TLSConfig = &tls.Config{ServerName: cfg.Host}
+
+	return &smtpClient{
+		fromEmail: fromEmail,
+		dialer:    dialer,
+	}, nil
+}
+
+func (m *smtpClient) Send(msg *Message, isSandbox bool) (int, error) {
+	if err := msg.validate(); err != nil {
+		return -1, err
+	}
+
+	message := newGomailMessage(m.fromEmail, msg)
+
+	if err := m.dialer.DialAndSend(message); err != nil {
+		return -1, fmt.Errorf("could not send email: %w", err)
+	}
+
+	return 200, nil
+}
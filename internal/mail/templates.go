@@ -0,0 +1,106 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+var (
+	templatesOnce sync.Once
+	templates     map[string]*emailTemplate
+	templatesErr  error
+)
+
+// emailTemplate is a template file parsed twice, the body block is executed
+// with html/template so the data is escaped by context.
+type emailTemplate struct {
+	text *template.Template
+	html *htmltemplate.Template
+}
+
+// parseTemplates parses every embedded template once, each file on its own
+// since all of them define the same blocks. Templates are keyed by
+// locale/file, e.g. es/user_invitation.tmpl.
+func parseTemplates() (map[string]*emailTemplate, error) {
+	templatesOnce.Do(func() {
+		files, err := fs.Glob(FS, "templates/*/*.tmpl")
+		if err != nil {
+			templatesErr = err
+			return
+		}
+
+		parsed := make(map[string]*emailTemplate, len(files))
+		for _, file := range files {
+			text, err := template.ParseFS(FS, file)
+			if err != nil {
+				templatesErr = err
+				return
+			}
+
+			html, err := htmltemplate.ParseFS(FS, file)
+			if err != nil {
+				templatesErr = err
+				return
+			}
+
+			parsed[strings.TrimPrefix(file, "templates/")] = &emailTemplate{text: text, html: html}
+		}
+
+		templates = parsed
+	})
+
+	return templates, templatesErr
+}
+
+// Render executes the subject, body and the optional text blocks of the
+// template into a message without recipients. When the template has no
+// translation for the locale it falls back to the base language and then to
+// DefaultLocale.
+func Render(locale, templateFile string, data any) (*Message, error) {
+	parsed, err := parseTemplates()
+	if err != nil {
+		return nil, err
+	}
+
+	var tmpl *emailTemplate
+	for _, l := range localeFallbacks(locale) {
+		if t, ok := parsed[l+"/"+templateFile]; ok {
+			tmpl = t
+			break
+		}
+	}
+
+	if tmpl == nil {
+		return nil, fmt.Errorf("template %q not found", templateFile)
+	}
+
+	subject := new(bytes.Buffer)
+	if err := tmpl.text.ExecuteTemplate(subject, "subject", data); err != nil {
+		return nil, err
+	}
+
+	body := new(bytes.Buffer)
+	if err := tmpl.html.ExecuteTemplate(body, "body", data); err != nil {
+		return nil, err
+	}
+
+	msg := &Message{
+		Subject: strings.TrimSpace(subject.String()),
+		HTML:    body.String(),
+	}
+
+	if tmpl.text.Lookup("text") != nil {
+		text := new(bytes.Buffer)
+		if err := tmpl.text.ExecuteTemplate(text, "text", data); err != nil {
+			return nil, err
+		}
+		msg.Text = strings.TrimSpace(text.String()) + "\n"
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,171 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+)
+
+var allTemplates = []string{
+	UserWelcomeTemplate,
+	EmailChangeConfirmTemplate,
+	EmailChangeNoticeTemplate,
+	NoteCommentTemplate,
+	AccountLockedTemplate,
+	MagicLinkTemplate,
+	AccountSuspendedTemplate,
+}
+
+// templateData has every field used by the templates.
+func templateData() map[string]any {
+	return map[string]any{
+		"Username":         "gopher",
+		"ActivationURL":    "https://example.com/confirm/token",
+		"ConfirmationURL":  "https://example.com/email/token",
+		"ExpiresInHours":   24,
+		"NewEmail":         "new@example.com",
+		"CommenterName":    "commenter",
+		"NoteTitle":        "Calculus",
+		"Comment":          "nice notes",
+		"NoteURL":          "https://example.com/notes/1",
+		"UnsubscribeURL":   "https://example.com/unsubscribe/token",
+		"IP":               "203.0.113.7",
+		"LockedMinutes":    15,
+		"LoginURL":         "https://example.com/magic-link/token",
+		"ExpiresInMinutes": 15,
+		"Reason":           "spam",
+		"Until":            "January 2, 2027 15:04 UTC",
+	}
+}
+
+func TestRenderTemplates(t *testing.T) {
+	for _, tmpl := range allTemplates {
+		for _, locale := range SupportedLocales {
+			t.Run(locale+"/"+tmpl, func(t *testing.T) {
+				msg, err := Render(locale, tmpl, templateData())
+				if err != nil {
+					t.Fatalf("render: %v", err)
+				}
+
+				if msg.Subject == "" || strings.Contains(msg.Subject, "\n") {
+					t.Errorf("subject = %q, want a single non empty line", msg.Subject)
+				}
+
+				if !strings.Contains(msg.HTML, "<html>") || !strings.Contains(msg.HTML, "gopher") {
+					t.Errorf("HTML part is missing the document or the data:\n%s", msg.HTML)
+				}
+
+				if msg.Text == "" || !strings.Contains(msg.Text, "gopher") {
+					t.Errorf("text part is missing the data:\n%s", msg.Text)
+				}
+
+				if strings.Contains(msg.Text, "<p>") {
+					t.Errorf("text part has HTML:\n%s", msg.Text)
+				}
+
+				for _, part := range []string{msg.Subject, msg.HTML, msg.Text} {
+					if strings.Contains(part, "<no value>") {
+						t.Errorf("missing template data:\n%s", part)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestRenderLocaleFallback(t *testing.T) {
+	tests := []struct {
+		name   string
+		locale string
+		want   string
+	}{
+		{name: "region falls back to language", locale: "es-MX", want: "es"},
+		{name: "unsupported falls back to default", locale: "fr", want: DefaultLocale},
+		{name: "empty uses default", locale: "", want: DefaultLocale},
+		{name: "invalid uses default", locale: "not a locale", want: DefaultLocale},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Render(tt.locale, MagicLinkTemplate, templateData())
+			if err != nil {
+				t.Fatalf("render: %v", err)
+			}
+
+			want, err := Render(tt.want, MagicLinkTemplate, templateData())
+			if err != nil {
+				t.Fatalf("render: %v", err)
+			}
+
+			if got.Subject != want.Subject || got.HTML != want.HTML || got.Text != want.Text {
+				t.Errorf("locale %q rendered %q, want the %s template %q", tt.locale, got.Subject, tt.want, want.Subject)
+			}
+		})
+	}
+}
+
+func TestRenderTranslations(t *testing.T) {
+	for _, tmpl := range allTemplates {
+		en, err := Render("en", tmpl, templateData())
+		if err != nil {
+			t.Fatalf("render %s: %v", tmpl, err)
+		}
+
+		es, err := Render("es", tmpl, templateData())
+		if err != nil {
+			t.Fatalf("render %s: %v", tmpl, err)
+		}
+
+		if en.Subject == es.Subject || en.Text == es.Text {
+			t.Errorf("%s is not translated to es", tmpl)
+		}
+	}
+}
+
+func TestRenderUnknownTemplate(t *testing.T) {
+	if _, err := Render("en", "missing.tmpl", nil); err == nil {
+		t.Error("expected an error for an unknown template")
+	}
+}
+
+func TestRenderEscapesHTML(t *testing.T) {
+	data := templateData()
+	data["Username"] = `<img src=x onerror="alert(1)">`
+	data["Comment"] = "<script>alert(1)</script>"
+	data["NoteURL"] = "javascript:alert(1)"
+
+	msg, err := Render("en", NoteCommentTemplate, data)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+
+	for _, raw := range []string{"<img", "<script>", "javascript:"} {
+		if strings.Contains(msg.HTML, raw) {
+			t.Errorf("HTML part has the unescaped %q:\n%s", raw, msg.HTML)
+		}
+	}
+
+	// the text part is plain text and keeps the data as is
+	if !strings.Contains(msg.Text, "<script>") {
+		t.Errorf("text part escaped the comment:\n%s", msg.Text)
+	}
+}
+
+func TestRenderEscapesSuspensionReason(t *testing.T) {
+	data := templateData()
+	data["Reason"] = `<a href="https://phishing.example">appeal here</a>`
+
+	for _, locale := range SupportedLocales {
+		msg, err := Render(locale, AccountSuspendedTemplate, data)
+		if err != nil {
+			t.Fatalf("render: %v", err)
+		}
+
+		if strings.Contains(msg.HTML, "<a href") {
+			t.Errorf("%s HTML part has the unescaped reason:\n%s", locale, msg.HTML)
+		}
+
+		if !strings.Contains(msg.HTML, "&lt;a href=") {
+			t.Errorf("%s HTML part is missing the escaped reason:\n%s", locale, msg.HTML)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package mail
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidUnsubscribeToken = errors.New("invalid unsubscribe token")
+
+// UnsubscribeSigner signs the tokens of the one-click unsubscribe links. A
+// token identifies a user and a notification category, it doesn't expire so
+// links in old emails keep working.
+type UnsubscribeSigner struct {
+	secret []byte
+}
+
+func NewUnsubscribeSigner(secret string) UnsubscribeSigner {
+	return UnsubscribeSigner{secret: []byte(secret)}
+}
+
+func (s UnsubscribeSigner) Token(userID int64, category string) string {
+	payload := strconv.FormatInt(userID, 10) + ":" + category
+
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(s.sign(payload))
+}
+
+// Parse checks the token signature and returns the user and category.
+func (s UnsubscribeSigner) Parse(token string) (int64, string, error) {
+	encodedPayload, encodedSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, "", ErrInvalidUnsubscribeToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return 0, "", ErrInvalidUnsubscribeToken
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return 0, "", ErrInvalidUnsubscribeToken
+	}
+
+	if !hmac.Equal(sig, s.sign(string(payload))) {
+		return 0, "", ErrInvalidUnsubscribeToken
+	}
+
+	rawID, category, ok := strings.Cut(string(payload), ":")
+	if !ok {
+		return 0, "", ErrInvalidUnsubscribeToken
+	}
+
+	userID, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil {
+		return 0, "", ErrInvalidUnsubscribeToken
+	}
+
+	return userID, category, nil
+}
+
+func (s UnsubscribeSigner) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte("unsubscribe:" + payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,217 @@
+package mail
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	BounceHard = "hard_bounce"
+	Complaint  = "complaint"
+
+	// webhookTolerance is how old a signed webhook can be, to limit replays
+	webhookTolerance = 5 * time.Minute
+)
+
+var ErrInvalidWebhookSignature = errors.New("invalid webhook signature")
+
+// DeliveryEvent is a hard bounce or complaint reported by a provider. Other
+// provider events are ignored when parsing.
+type DeliveryEvent struct {
+	Provider   string
+	Email      string
+	Kind       string
+	Reason     string
+	OccurredAt time.Time
+}
+
+// VerifySendGridSignature checks the ECDSA signature of the SendGrid event
+// webhook, publicKey is the base64 verification key of the SendGrid settings.
+func VerifySendGridSignature(publicKey, signature, timestamp string, body []byte) error {
+	if publicKey == "" || signature == "" {
+		return ErrInvalidWebhookSignature
+	}
+
+	if err := checkWebhookTimestamp(timestamp); err != nil {
+		return err
+	}
+
+	der, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return err
+	}
+
+	key, ok := parsed.(*ecdsa.PublicKey)
+	if !ok {
+		return errors.New("sendgrid webhook key is not an ECDSA key")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return ErrInvalidWebhookSignature
+	}
+
+	hash := sha256.Sum256(append([]byte(timestamp), body...))
+	if !ecdsa.VerifyASN1(key, hash[:], sig) {
+		return ErrInvalidWebhookSignature
+	}
+
+	return nil
+}
+
+// VerifyResendSignature checks the Svix signature Resend webhooks are sent
+// with, secret is the whsec_ signing secret of the webhook.
+func VerifyResendSignature(secret, id, timestamp, signatures string, body []byte) error {
+	if secret == "" || id == "" || signatures == "" {
+		return ErrInvalidWebhookSignature
+	}
+
+	if err := checkWebhookTimestamp(timestamp); err != nil {
+		return err
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, "whsec_"))
+	if err != nil {
+		return err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id + "." + timestamp + "."))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+
+	// the header can hold several space separated signatures while the
+	// secret is being rotated
+	for _, s := range strings.Fields(signatures) {
+		version, sig, ok := strings.Cut(s, ",")
+		if !ok || version != "v1" {
+			continue
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(sig)
+		if err != nil {
+			continue
+		}
+
+		if hmac.Equal(decoded, expected) {
+			return nil
+		}
+	}
+
+	return ErrInvalidWebhookSignature
+}
+
+func checkWebhookTimestamp(timestamp string) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidWebhookSignature
+	}
+
+	age := time.Since(time.Unix(ts, 0))
+	if age > webhookTolerance || age < -webhookTolerance {
+		return errors.New("webhook timestamp out of tolerance")
+	}
+
+	return nil
+}
+
+type sendGridEvent struct {
+	Email     string `json:"email"`
+	Event     string `json:"event"`
+	Type      string `json:"type"`
+	Reason    string `json:"reason"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// ParseSendGridEvents returns the hard bounces and spam reports of a SendGrid
+// event webhook batch. Blocked messages are temporary and ignored.
+func ParseSendGridEvents(body []byte) ([]DeliveryEvent, error) {
+	var raw []sendGridEvent
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, err
+	}
+
+	events := []DeliveryEvent{}
+	for _, e := range raw {
+		event := DeliveryEvent{
+			Provider:   ProviderSendGrid,
+			Email:      e.Email,
+			Reason:     e.Reason,
+			OccurredAt: time.Unix(e.Timestamp, 0),
+		}
+
+		switch {
+		case e.Event == "bounce" && e.Type != "blocked":
+			event.Kind = BounceHard
+		case e.Event == "spamreport":
+			event.Kind = Complaint
+			event.Reason = "marked as spam"
+		default:
+			continue
+		}
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+type resendEvent struct {
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      struct {
+		To     []string `json:"to"`
+		Bounce struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"bounce"`
+	} `json:"data"`
+}
+
+// ParseResendEvent returns the bounces and complaints of a Resend webhook,
+// transient bounces are ignored.
+func ParseResendEvent(body []byte) ([]DeliveryEvent, error) {
+	var raw resendEvent
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, err
+	}
+
+	var kind, reason string
+	switch raw.Type {
+	case "email.bounced":
+		if strings.EqualFold(raw.Data.Bounce.Type, "Transient") {
+			return nil, nil
+		}
+		kind, reason = BounceHard, raw.Data.Bounce.Message
+	case "email.complained":
+		kind, reason = Complaint, "marked as spam"
+	default:
+		return nil, nil
+	}
+
+	events := make([]DeliveryEvent, 0, len(raw.Data.To))
+	for _, to := range raw.Data.To {
+		events = append(events, DeliveryEvent{
+			Provider:   ProviderResend,
+			Email:      to,
+			Kind:       kind,
+			Reason:     reason,
+			OccurredAt: raw.CreatedAt,
+		})
+	}
+
+	return events, nil
+}
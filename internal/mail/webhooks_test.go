@@ -0,0 +1,260 @@
+package mail
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sendGridKey returns a verification key in the format of the SendGrid
+// settings and a function signing like SendGrid does.
+func sendGridKey(t *testing.T) (string, func(timestamp string, body []byte) string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(timestamp string, body []byte) string {
+		hash := sha256.Sum256(append([]byte(timestamp), body...))
+		sig, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.StdEncoding.EncodeToString(sig)
+	}
+
+	return base64.StdEncoding.EncodeToString(der), sign
+}
+
+func unixTimestamp(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+func TestVerifySendGridSignature(t *testing.T) {
+	publicKey, sign := sendGridKey(t)
+	otherKey, _ := sendGridKey(t)
+
+	body := []byte(`[{"email":"gopher@example.com","event":"bounce"}]`)
+	now := unixTimestamp(time.Now())
+	stale := unixTimestamp(time.Now().Add(-2 * webhookTolerance))
+
+	tests := []struct {
+		name      string
+		publicKey string
+		signature string
+		timestamp string
+		body      []byte
+		valid     bool
+	}{
+		{name: "valid signature", publicKey: publicKey, signature: sign(now, body), timestamp: now, body: body, valid: true},
+		{name: "tampered body", publicKey: publicKey, signature: sign(now, body), timestamp: now, body: []byte(`[{"email":"victim@example.com","event":"bounce"}]`)},
+		{name: "tampered timestamp", publicKey: publicKey, signature: sign(now, body), timestamp: unixTimestamp(time.Now().Add(time.Minute)), body: body},
+		{name: "stale timestamp", publicKey: publicKey, signature: sign(stale, body), timestamp: stale, body: body},
+		{name: "missing timestamp", publicKey: publicKey, signature: sign("", body), timestamp: "", body: body},
+		{name: "missing signature", publicKey: publicKey, timestamp: now, body: body},
+		{name: "signature not base64", publicKey: publicKey, signature: "not base64!", timestamp: now, body: body},
+		{name: "signed with another key", publicKey: otherKey, signature: sign(now, body), timestamp: now, body: body},
+		{name: "no key configured", signature: sign(now, body), timestamp: now, body: body},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifySendGridSignature(tt.publicKey, tt.signature, tt.timestamp, tt.body)
+			if tt.valid != (err == nil) {
+				t.Errorf("VerifySendGridSignature error = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func resendSign(secret, id, timestamp string, body []byte) string {
+	key, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, "whsec_"))
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id + "." + timestamp + "."))
+	mac.Write(body)
+
+	return "v1," + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyResendSignature(t *testing.T) {
+	secret := "whsec_" + base64.StdEncoding.EncodeToString([]byte("resend-signing-secret"))
+	oldSecret := "whsec_" + base64.StdEncoding.EncodeToString([]byte("rotated-signing-secret"))
+
+	id := "msg_123"
+	body := []byte(`{"type":"email.bounced"}`)
+	now := unixTimestamp(time.Now())
+	stale := unixTimestamp(time.Now().Add(-2 * webhookTolerance))
+	future := unixTimestamp(time.Now().Add(2 * webhookTolerance))
+
+	tests := []struct {
+		name       string
+		id         string
+		timestamp  string
+		signatures string
+		body       []byte
+		valid      bool
+	}{
+		{name: "valid signature", id: id, timestamp: now, signatures: resendSign(secret, id, now, body), body: body, valid: true},
+		{
+			name:       "one of several signatures",
+			id:         id,
+			timestamp:  now,
+			signatures: resendSign(oldSecret, id, now, body) + " " + resendSign(secret, id, now, body),
+			body:       body,
+			valid:      true,
+		},
+		{
+			name:       "none of several signatures",
+			id:         id,
+			timestamp:  now,
+			signatures: resendSign(oldSecret, id, now, body) + " v1,bm90IHRoZSBzaWduYXR1cmU=",
+			body:       body,
+		},
+		{name: "tampered body", id: id, timestamp: now, signatures: resendSign(secret, id, now, body), body: []byte(`{"type":"email.complained"}`)},
+		{name: "other message id", id: "msg_456", timestamp: now, signatures: resendSign(secret, id, now, body), body: body},
+		{name: "stale timestamp", id: id, timestamp: stale, signatures: resendSign(secret, id, stale, body), body: body},
+		{name: "future timestamp", id: id, timestamp: future, signatures: resendSign(secret, id, future, body), body: body},
+		{name: "missing timestamp", id: id, signatures: resendSign(secret, id, "", body), body: body},
+		{name: "missing id", timestamp: now, signatures: resendSign(secret, "", now, body), body: body},
+		{name: "missing signature", id: id, timestamp: now, body: body},
+		{name: "unknown version", id: id, timestamp: now, signatures: strings.Replace(resendSign(secret, id, now, body), "v1,", "v2,", 1), body: body},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyResendSignature(secret, tt.id, tt.timestamp, tt.signatures, tt.body)
+			if tt.valid != (err == nil) {
+				t.Errorf("VerifyResendSignature error = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestVerifyResendSignatureWithoutSecret(t *testing.T) {
+	now := unixTimestamp(time.Now())
+	body := []byte(`{}`)
+
+	if err := VerifyResendSignature("", "msg_123", now, resendSign("", "msg_123", now, body), body); err == nil {
+		t.Error("expected an error without a signing secret")
+	}
+}
+
+func TestParseSendGridEvents(t *testing.T) {
+	body := []byte(`[
+		{"email":"hard@example.com","event":"bounce","type":"bounce","reason":"550 no such user","timestamp":1700000000},
+		{"email":"blocked@example.com","event":"bounce","type":"blocked","reason":"temporarily blocked","timestamp":1700000000},
+		{"email":"spam@example.com","event":"spamreport","timestamp":1700000001},
+		{"email":"delivered@example.com","event":"delivered","timestamp":1700000002},
+		{"email":"unknown@example.com","event":"something_new","timestamp":1700000003}
+	]`)
+
+	events, err := ParseSendGridEvents(body)
+	if err != nil {
+		t.Fatalf("ParseSendGridEvents: %v", err)
+	}
+
+	want := []DeliveryEvent{
+		{Provider: ProviderSendGrid, Email: "hard@example.com", Kind: BounceHard, Reason: "550 no such user", OccurredAt: time.Unix(1700000000, 0)},
+		{Provider: ProviderSendGrid, Email: "spam@example.com", Kind: Complaint, Reason: "marked as spam", OccurredAt: time.Unix(1700000001, 0)},
+	}
+
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(events), len(want), events)
+	}
+
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, events[i], want[i])
+		}
+	}
+}
+
+func TestParseSendGridEventsInvalid(t *testing.T) {
+	if _, err := ParseSendGridEvents([]byte(`{"email":"not a batch"}`)); err == nil {
+		t.Error("expected an error for a body that is not a batch")
+	}
+}
+
+func TestParseResendEvent(t *testing.T) {
+	occurredAt := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		body string
+		want []DeliveryEvent
+	}{
+		{
+			name: "permanent bounce",
+			body: `{"type":"email.bounced","created_at":"2026-01-02T15:04:05Z","data":{"to":["a@example.com","b@example.com"],"bounce":{"type":"Permanent","message":"mailbox does not exist"}}}`,
+			want: []DeliveryEvent{
+				{Provider: ProviderResend, Email: "a@example.com", Kind: BounceHard, Reason: "mailbox does not exist", OccurredAt: occurredAt},
+				{Provider: ProviderResend, Email: "b@example.com", Kind: BounceHard, Reason: "mailbox does not exist", OccurredAt: occurredAt},
+			},
+		},
+		{
+			name: "transient bounce",
+			body: `{"type":"email.bounced","created_at":"2026-01-02T15:04:05Z","data":{"to":["a@example.com"],"bounce":{"type":"transient","message":"mailbox full"}}}`,
+		},
+		{
+			name: "complaint",
+			body: `{"type":"email.complained","created_at":"2026-01-02T15:04:05Z","data":{"to":["a@example.com"]}}`,
+			want: []DeliveryEvent{
+				{Provider: ProviderResend, Email: "a@example.com", Kind: Complaint, Reason: "marked as spam", OccurredAt: occurredAt},
+			},
+		},
+		{
+			name: "delivered",
+			body: `{"type":"email.delivered","created_at":"2026-01-02T15:04:05Z","data":{"to":["a@example.com"]}}`,
+		},
+		{
+			name: "unknown event type",
+			body: `{"type":"contact.created","created_at":"2026-01-02T15:04:05Z","data":{}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := ParseResendEvent([]byte(tt.body))
+			if err != nil {
+				t.Fatalf("ParseResendEvent: %v", err)
+			}
+
+			if len(events) != len(tt.want) {
+				t.Fatalf("got %d events, want %d: %+v", len(events), len(tt.want), events)
+			}
+
+			for i := range tt.want {
+				if !events[i].OccurredAt.Equal(tt.want[i].OccurredAt) {
+					t.Errorf("event %d occurred at %v, want %v", i, events[i].OccurredAt, tt.want[i].OccurredAt)
+				}
+
+				events[i].OccurredAt = tt.want[i].OccurredAt
+				if events[i] != tt.want[i] {
+					t.Errorf("event %d = %+v, want %+v", i, events[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseResendEventInvalid(t *testing.T) {
+	if _, err := ParseResendEvent([]byte(`not json`)); err == nil {
+		t.Error("expected an error for a body that is not JSON")
+	}
+}
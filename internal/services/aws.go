@@ -51,6 +51,19 @@ func (u *S3Uploader) UploadFile(bucketName, objectKey string, file io.Reader) er
 	return nil
 }
 
+// GetFile returns the object body, the caller must close it.
+func (u *S3Uploader) GetFile(bucketName, objectKey string) (io.ReadCloser, error) {
+	out, err := u.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out.Body, nil
+}
+
 func (u *S3Uploader) DeleteFile(bucketName, objectKey string) error {
 	_, err := u.svc.DeleteObject(&s3.DeleteObjectInput{
 		Bucket: aws.String(bucketName),
@@ -0,0 +1,161 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+
+	"github.com/lib/pq"
+)
+
+// APIKey lets scripts act as the user, privileged endpoints also need the
+// permission among the scopes of the key.
+type APIKey struct {
+	ID         int64    `json:"id"`
+	UserID     int64    `json:"-"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  string   `json:"created_at"`
+	LastUsedAt *string  `json:"last_used_at"`
+}
+
+func (k *APIKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+type APIKeyStore struct {
+	db *sql.DB
+}
+
+func (s *APIKeyStore) Create(ctx context.Context, key *APIKey, keyHash string) error {
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return s.db.QueryRowContext(
+		ctx,
+		query,
+		key.UserID,
+		key.Name,
+		key.Prefix,
+		keyHash,
+		pq.Array(key.Scopes),
+	).Scan(
+		&key.ID,
+		&key.CreatedAt,
+	)
+}
+
+// GetByHash returns the key with the hash and records its use, the last use
+// is only written once a minute.
+func (s *APIKeyStore) GetByHash(ctx context.Context, keyHash string) (*APIKey, error) {
+	query := `
+		UPDATE api_keys SET last_used_at = CASE
+				WHEN last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute' THEN NOW()
+				ELSE last_used_at
+			END
+		WHERE key_hash = $1
+		RETURNING id, user_id, name, prefix, scopes, created_at, last_used_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	key := &APIKey{}
+	err := s.db.QueryRowContext(ctx, query, keyHash).Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		pq.Array(&key.Scopes),
+		&key.CreatedAt,
+		&key.LastUsedAt,
+	)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+func (s *APIKeyStore) ListByUser(ctx context.Context, userID int64) ([]*APIKey, error) {
+	query := `
+		SELECT id, user_id, name, prefix, scopes, created_at, last_used_at
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		key := &APIKey{}
+		if err := rows.Scan(
+			&key.ID,
+			&key.UserID,
+			&key.Name,
+			&key.Prefix,
+			pq.Array(&key.Scopes),
+			&key.CreatedAt,
+			&key.LastUsedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// revokeAPIKeys deletes every key of the user in the caller transaction, so
+// the keys created by whoever knew the old password stop working.
+func revokeAPIKeys(ctx context.Context, tx *sql.Tx, userID int64) error {
+	query := `DELETE FROM api_keys WHERE user_id = $1`
+
+	_, err := tx.ExecContext(ctx, query, userID)
+	return err
+}
+
+// Revoke deletes a key of the user, scripts using it get a 401 right away.
+func (s *APIKeyStore) Revoke(ctx context.Context, userID, keyID int64) error {
+	query := `DELETE FROM api_keys WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, keyID, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+)
+
+const (
+	AuditAccountLocked    = "account_locked"
+	AuditAccountUnlocked  = "account_unlocked"
+	AuditIPLocked         = "ip_locked"
+	AuditIdentityLinked   = "identity_linked"
+	AuditRoleChanged      = "role_changed"
+	AuditReviewDeleted    = "review_deleted"
+	AuditMemberChanged    = "school_member_changed"
+	AuditMemberRemoved    = "school_member_removed"
+	AuditUserSuspended    = "user_suspended"
+	AuditUserBanned       = "user_banned"
+	AuditSuspensionLifted = "suspension_lifted"
+)
+
+type AuditEntry struct {
+	ID           int64           `json:"id"`
+	Action       string          `json:"action"`
+	ActorID      *int64          `json:"actor_id"`
+	TargetUserID *int64          `json:"target_user_id"`
+	IP           string          `json:"ip"`
+	Details      json.RawMessage `json:"details"`
+	CreatedAt    string          `json:"created_at"`
+}
+
+// NewAuditEntry encodes the details of the entry, they can be nil.
+func NewAuditEntry(action string, actorID, targetUserID *int64, ip string, details any) (*AuditEntry, error) {
+	raw := json.RawMessage("{}")
+	if details != nil {
+		encoded, err := json.Marshal(details)
+		if err != nil {
+			return nil, err
+		}
+		raw = encoded
+	}
+
+	return &AuditEntry{
+		Action:       action,
+		ActorID:      actorID,
+		TargetUserID: targetUserID,
+		IP:           ip,
+		Details:      raw,
+	}, nil
+}
+
+type AuditStore struct {
+	db *sql.DB
+}
+
+func (s *AuditStore) Record(ctx context.Context, e *AuditEntry) error {
+	query := `
+		INSERT INTO audit_log (action, actor_id, target_user_id, ip, details)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return s.db.QueryRowContext(
+		ctx,
+		query,
+		e.Action,
+		e.ActorID,
+		e.TargetUserID,
+		e.IP,
+		e.Details,
+	).Scan(
+		&e.ID,
+		&e.CreatedAt,
+	)
+}
+
+// List returns the entries with the given action, or all of them when action
+// is empty.
+func (s *AuditStore) List(ctx context.Context, action string, fq PaginatedFeedQuery) ([]*AuditEntry, error) {
+	query := `
+		SELECT id, action, actor_id, target_user_id, ip, details, created_at
+		FROM audit_log
+		WHERE ($1 = '' OR action = $1)
+		ORDER BY created_at ` + sortDirection(fq.Sort) + `, id ` + sortDirection(fq.Sort) + `
+		LIMIT $2 OFFSET $3
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, action, fq.Limit, fq.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*AuditEntry{}
+	for rows.Next() {
+		e := &AuditEntry{}
+		if err := rows.Scan(
+			&e.ID,
+			&e.Action,
+			&e.ActorID,
+			&e.TargetUserID,
+			&e.IP,
+			&e.Details,
+			&e.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
@@ -0,0 +1,220 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+type Comment struct {
+	ID         int64  `json:"id"`
+	NoteID     int64  `json:"note_id"`
+	UserID     int64  `json:"user_id"`
+	Username   string `json:"username"`
+	ParentID   *int64 `json:"parent_id"`
+	Content    string `json:"content"`
+	ReplyCount int    `json:"reply_count"`
+	IsDeleted  bool   `json:"is_deleted"`
+	CreatedAt  string `json:"created_at"`
+	UpdatedAt  string `json:"updated_at"`
+}
+
+type CommentStore struct {
+	db *sql.DB
+}
+
+func (s *CommentStore) Create(ctx context.Context, c *Comment) error {
+	query := `
+		INSERT INTO comments (note_id, user_id, parent_id, content)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		c.NoteID,
+		c.UserID,
+		c.ParentID,
+		c.Content,
+	).Scan(
+		&c.ID,
+		&c.CreatedAt,
+		&c.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *CommentStore) GetByID(ctx context.Context, commentID int64) (*Comment, error) {
+	query := `
+		SELECT c.id, c.note_id, c.user_id, u.username, c.parent_id, c.content,
+		(SELECT COUNT(*) FROM comments r WHERE r.parent_id = c.id AND r.deleted_at IS NULL) AS reply_count,
+		c.deleted_at IS NOT NULL, c.created_at, c.updated_at
+		FROM comments c
+		JOIN users u ON u.id = c.user_id
+		WHERE c.id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	c := &Comment{}
+	err := s.db.QueryRowContext(ctx, query, commentID).Scan(
+		&c.ID,
+		&c.NoteID,
+		&c.UserID,
+		&c.Username,
+		&c.ParentID,
+		&c.Content,
+		&c.ReplyCount,
+		&c.IsDeleted,
+		&c.CreatedAt,
+		&c.UpdatedAt,
+	)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	c.hideIfDeleted()
+
+	return c, nil
+}
+
+// GetByNoteID returns the top level comments of a note, replies are fetched
+// separately with GetReplies.
+func (s *CommentStore) GetByNoteID(ctx context.Context, noteID int64, fq PaginatedFeedQuery) ([]*Comment, error) {
+	query := `
+		SELECT c.id, c.note_id, c.user_id, u.username, c.parent_id, c.content,
+		(SELECT COUNT(*) FROM comments r WHERE r.parent_id = c.id AND r.deleted_at IS NULL) AS reply_count,
+		c.deleted_at IS NOT NULL, c.created_at, c.updated_at
+		FROM comments c
+		JOIN users u ON u.id = c.user_id
+		WHERE c.note_id = $1 AND c.parent_id IS NULL
+		ORDER BY c.created_at ` + sortDirection(fq.Sort) + `
+		LIMIT $2 OFFSET $3
+	`
+
+	return s.list(ctx, query, noteID, fq.Limit, fq.Offset)
+}
+
+func (s *CommentStore) GetReplies(ctx context.Context, commentID int64, fq PaginatedFeedQuery) ([]*Comment, error) {
+	query := `
+		SELECT c.id, c.note_id, c.user_id, u.username, c.parent_id, c.content,
+		(SELECT COUNT(*) FROM comments r WHERE r.parent_id = c.id AND r.deleted_at IS NULL) AS reply_count,
+		c.deleted_at IS NOT NULL, c.created_at, c.updated_at
+		FROM comments c
+		JOIN users u ON u.id = c.user_id
+		WHERE c.parent_id = $1
+		ORDER BY c.created_at ` + sortDirection(fq.Sort) + `
+		LIMIT $2 OFFSET $3
+	`
+
+	return s.list(ctx, query, commentID, fq.Limit, fq.Offset)
+}
+
+func (s *CommentStore) Update(ctx context.Context, c *Comment) error {
+	query := `
+		UPDATE comments SET content = $1, updated_at = NOW()
+		WHERE id = $2 AND deleted_at IS NULL
+		RETURNING updated_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(ctx, query, c.Content, c.ID).Scan(&c.UpdatedAt)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return ErrNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete soft deletes a comment so the replies below it keep their thread.
+func (s *CommentStore) Delete(ctx context.Context, commentID int64) error {
+	query := `
+		UPDATE comments SET deleted_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, commentID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *CommentStore) list(ctx context.Context, query string, args ...any) ([]*Comment, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	comments := []*Comment{}
+	for rows.Next() {
+		c := &Comment{}
+		if err := rows.Scan(
+			&c.ID,
+			&c.NoteID,
+			&c.UserID,
+			&c.Username,
+			&c.ParentID,
+			&c.Content,
+			&c.ReplyCount,
+			&c.IsDeleted,
+			&c.CreatedAt,
+			&c.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		c.hideIfDeleted()
+		comments = append(comments, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
+
+func (c *Comment) hideIfDeleted() {
+	if c.IsDeleted {
+		c.Content = ""
+	}
+}
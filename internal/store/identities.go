@@ -0,0 +1,181 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+var (
+	ErrIdentityTaken         = errors.New("this account is already linked to another user")
+	ErrProviderAlreadyLinked = errors.New("a different account of this provider is already linked")
+)
+
+// Identity is an external account (OAuth provider) the user can log in with.
+type Identity struct {
+	ID             int64   `json:"id"`
+	UserID         int64   `json:"-"`
+	Provider       string  `json:"provider"`
+	ProviderUserID string  `json:"-"`
+	Email          string  `json:"email"`
+	EmailVerified  bool    `json:"email_verified"`
+	CreatedAt      string  `json:"created_at"`
+	LastLoginAt    *string `json:"last_login_at"`
+}
+
+type IdentityStore struct {
+	db *sql.DB
+}
+
+func (s *IdentityStore) GetByProvider(ctx context.Context, provider, providerUserID string) (*Identity, error) {
+	query := `
+		SELECT id, user_id, provider, provider_user_id, email, email_verified, created_at, last_login_at
+		FROM user_identities
+		WHERE provider = $1 AND provider_user_id = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	identity := &Identity{}
+	err := s.db.QueryRowContext(ctx, query, provider, providerUserID).Scan(
+		&identity.ID,
+		&identity.UserID,
+		&identity.Provider,
+		&identity.ProviderUserID,
+		&identity.Email,
+		&identity.EmailVerified,
+		&identity.CreatedAt,
+		&identity.LastLoginAt,
+	)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return identity, nil
+}
+
+func (s *IdentityStore) ListByUser(ctx context.Context, userID int64) ([]*Identity, error) {
+	query := `
+		SELECT id, user_id, provider, provider_user_id, email, email_verified, created_at, last_login_at
+		FROM user_identities
+		WHERE user_id = $1
+		ORDER BY created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []*Identity{}
+	for rows.Next() {
+		identity := &Identity{}
+		if err := rows.Scan(
+			&identity.ID,
+			&identity.UserID,
+			&identity.Provider,
+			&identity.ProviderUserID,
+			&identity.Email,
+			&identity.EmailVerified,
+			&identity.CreatedAt,
+			&identity.LastLoginAt,
+		); err != nil {
+			return nil, err
+		}
+
+		identities = append(identities, identity)
+	}
+
+	return identities, rows.Err()
+}
+
+func (s *IdentityStore) Link(ctx context.Context, identity *Identity) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		return linkIdentity(ctx, tx, identity)
+	})
+}
+
+// TouchLogin records a login with the identity and refreshes the email the
+// provider reports.
+func (s *IdentityStore) TouchLogin(ctx context.Context, identity *Identity) error {
+	query := `
+		UPDATE user_identities SET last_login_at = NOW(), email = $2, email_verified = $3
+		WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, identity.ID, identity.Email, identity.EmailVerified)
+	return err
+}
+
+func (s *IdentityStore) Unlink(ctx context.Context, userID int64, provider string) error {
+	query := `DELETE FROM user_identities WHERE user_id = $1 AND provider = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, provider)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func linkIdentity(ctx context.Context, tx *sql.Tx, identity *Identity) error {
+	query := `
+		INSERT INTO user_identities (user_id, provider, provider_user_id, email, email_verified, last_login_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		RETURNING id, created_at, last_login_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := tx.QueryRowContext(
+		ctx,
+		query,
+		identity.UserID,
+		identity.Provider,
+		identity.ProviderUserID,
+		identity.Email,
+		identity.EmailVerified,
+	).Scan(
+		&identity.ID,
+		&identity.CreatedAt,
+		&identity.LastLoginAt,
+	)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "user_identities_provider_user_key"`:
+			return ErrIdentityTaken
+		case err.Error() == `pq: duplicate key value violates unique constraint "user_identities_user_provider_key"`:
+			return ErrProviderAlreadyLinked
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
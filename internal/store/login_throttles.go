@@ -0,0 +1,118 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const (
+	ThrottleAccount = "account"
+	ThrottleIP      = "ip"
+	// ThrottleMagicLink counts the login links sent to an email
+	ThrottleMagicLink = "magic_link"
+)
+
+// LoginThrottle counts the recent failed logins of an account or an IP.
+type LoginThrottle struct {
+	Failures    int
+	Lockouts    int
+	LockedUntil *time.Time
+}
+
+func (t *LoginThrottle) IsLocked(now time.Time) bool {
+	return t.LockedUntil != nil && t.LockedUntil.After(now)
+}
+
+type LoginThrottleStore struct {
+	db *sql.DB
+}
+
+func (s *LoginThrottleStore) Get(ctx context.Context, scope, key string) (*LoginThrottle, error) {
+	query := `
+		SELECT failures, lockouts, locked_until
+		FROM login_throttles
+		WHERE scope = $1 AND key = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	throttle := &LoginThrottle{}
+	err := s.db.QueryRowContext(ctx, query, scope, key).Scan(
+		&throttle.Failures,
+		&throttle.Lockouts,
+		&throttle.LockedUntil,
+	)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return &LoginThrottle{}, nil
+		default:
+			return nil, err
+		}
+	}
+
+	return throttle, nil
+}
+
+// RecordFailure counts a failed login, failures older than the window are
+// forgotten so the count starts again.
+func (s *LoginThrottleStore) RecordFailure(ctx context.Context, scope, key string, window time.Duration) (*LoginThrottle, error) {
+	query := `
+		INSERT INTO login_throttles (scope, key, failures, last_failure_at)
+		VALUES ($1, $2, 1, NOW())
+		ON CONFLICT (scope, key) DO UPDATE
+		SET failures = CASE
+				WHEN login_throttles.last_failure_at < $3 THEN 1
+				ELSE login_throttles.failures + 1
+			END,
+			last_failure_at = NOW()
+		RETURNING failures, lockouts, locked_until
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	throttle := &LoginThrottle{}
+	err := s.db.QueryRowContext(ctx, query, scope, key, time.Now().Add(-window)).Scan(
+		&throttle.Failures,
+		&throttle.Lockouts,
+		&throttle.LockedUntil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return throttle, nil
+}
+
+// Block rejects logins until the given time. A lockout also starts the
+// failures count again and is remembered to make the next one longer.
+func (s *LoginThrottleStore) Block(ctx context.Context, scope, key string, until time.Time, lockout bool) error {
+	query := `
+		UPDATE login_throttles
+		SET locked_until = $3,
+			failures = CASE WHEN $4 THEN 0 ELSE failures END,
+			lockouts = CASE WHEN $4 THEN lockouts + 1 ELSE lockouts END
+		WHERE scope = $1 AND key = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, scope, key, until, lockout)
+	return err
+}
+
+// Reset forgets the failures and lockouts, after a successful login or when
+// an admin unlocks the account.
+func (s *LoginThrottleStore) Reset(ctx context.Context, scope, key string) error {
+	query := `DELETE FROM login_throttles WHERE scope = $1 AND key = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, scope, key)
+	return err
+}
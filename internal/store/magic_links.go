@@ -0,0 +1,71 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"time"
+)
+
+type MagicLinkStore struct {
+	db *sql.DB
+}
+
+// Create replaces the login link of the user with a new one and queues the
+// email with the link in the same transaction.
+func (s *MagicLinkStore) Create(ctx context.Context, userID int64, token string, exp time.Duration, email *OutboxEmail) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		query := `DELETE FROM magic_links WHERE user_id = $1`
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+			return err
+		}
+
+		query = `
+			INSERT INTO magic_links (token, user_id, expiry)
+			VALUES ($1, $2, $3)
+		`
+		if _, err := tx.ExecContext(ctx, query, token, userID, time.Now().Add(exp)); err != nil {
+			return err
+		}
+
+		return enqueueEmail(ctx, tx, email)
+	})
+}
+
+// Consume deletes the link so it can't be used again and returns the user it
+// logs in, it returns ErrNotFound if the link is unknown or expired.
+func (s *MagicLinkStore) Consume(ctx context.Context, token string) (int64, error) {
+	hash := sha256.Sum256([]byte(token))
+	hashToken := hex.EncodeToString(hash[:])
+
+	query := `
+		DELETE FROM magic_links
+		WHERE token = $1
+		RETURNING user_id, expiry > NOW()
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var userID int64
+	var valid bool
+	err := s.db.QueryRowContext(ctx, query, hashToken).Scan(&userID, &valid)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return 0, ErrNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	if !valid {
+		return 0, ErrNotFound
+	}
+
+	return userID, nil
+}
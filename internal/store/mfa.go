@@ -0,0 +1,219 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+var (
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrInvalidMFACode    = errors.New("invalid two-factor authentication code")
+)
+
+// MFASettings holds the TOTP state of a user, Secret is set as soon as the
+// enrollment starts but only used once Enabled.
+type MFASettings struct {
+	UserID   int64
+	Secret   string
+	Enabled  bool
+	LastStep int64
+}
+
+type MFAStore struct {
+	db *sql.DB
+}
+
+func (s *MFAStore) Get(ctx context.Context, userID int64) (*MFASettings, error) {
+	query := `
+		SELECT id, COALESCE(mfa_secret, ''), mfa_enabled, mfa_last_step
+		FROM users
+		WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	settings := &MFASettings{}
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(
+		&settings.UserID,
+		&settings.Secret,
+		&settings.Enabled,
+		&settings.LastStep,
+	)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return settings, nil
+}
+
+// SetPendingSecret starts the enrollment, it replaces the secret of a
+// previous unfinished enrollment.
+func (s *MFAStore) SetPendingSecret(ctx context.Context, userID int64, secret string) error {
+	query := `UPDATE users SET mfa_secret = $1 WHERE id = $2 AND mfa_enabled = false`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, secret, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+
+	return nil
+}
+
+// Enable finishes the enrollment with the step of the code used to confirm
+// it and replaces the recovery codes.
+func (s *MFAStore) Enable(ctx context.Context, userID, step int64, codeHashes []string) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			UPDATE users SET mfa_enabled = true, mfa_last_step = $2
+			WHERE id = $1 AND mfa_enabled = false AND mfa_secret IS NOT NULL
+		`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		res, err := tx.ExecContext(ctx, query, userID, step)
+		if err != nil {
+			return err
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rows == 0 {
+			return ErrMFAAlreadyEnabled
+		}
+
+		return replaceRecoveryCodes(ctx, tx, userID, codeHashes)
+	})
+}
+
+// Disable removes the secret and the recovery codes.
+func (s *MFAStore) Disable(ctx context.Context, userID int64) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			UPDATE users SET mfa_enabled = false, mfa_secret = NULL, mfa_last_step = 0
+			WHERE id = $1
+		`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+			return err
+		}
+
+		return replaceRecoveryCodes(ctx, tx, userID, nil)
+	})
+}
+
+// UseStep records the TOTP step as used, it fails with ErrInvalidMFACode if
+// the step or a later one was already used.
+func (s *MFAStore) UseStep(ctx context.Context, userID, step int64) error {
+	query := `
+		UPDATE users SET mfa_last_step = $2
+		WHERE id = $1 AND mfa_enabled = true AND mfa_last_step < $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used.
+func (s *MFAStore) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) error {
+	query := `
+		UPDATE mfa_recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, codeHash)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+func (s *MFAStore) ReplaceRecoveryCodes(ctx context.Context, userID int64, codeHashes []string) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		return replaceRecoveryCodes(ctx, tx, userID, codeHashes)
+	})
+}
+
+func (s *MFAStore) CountRecoveryCodes(ctx context.Context, userID int64) (int, error) {
+	query := `SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var count int
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int64, codeHashes []string) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)`
+	for _, hash := range codeHashes {
+		if _, err := tx.ExecContext(ctx, query, userID, hash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
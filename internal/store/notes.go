@@ -3,43 +3,100 @@ package store
 import (
 	"context"
 	"database/sql"
+	"strings"
 
 	"github.com/lib/pq"
 )
 
 type Note struct {
-	ID          int64    `json:"id"`
-	Content     string   `json:"content"`
-	Subject     string   `json:"subject"`
-	Title       string   `json:"title"`
-	FilesURL    []string `json:"files_url"`
-	UserID      int64    `json:"user_id"`
-	ProfessorID int64    `json:"professor_id"`
-	CreatedAt   string   `json:"created_at"`
+	ID            int64       `json:"id"`
+	Content       string      `json:"content"`
+	Subject       string      `json:"subject"`
+	Title         string      `json:"title"`
+	FilesURL      []string    `json:"files_url"`
+	UserID        int64       `json:"user_id"`
+	ProfessorID   int64       `json:"professor_id"`
+	CommentsCount int         `json:"comments_count"`
+	Files         []*NoteFile `json:"files,omitempty"`
+	CreatedAt     string      `json:"created_at"`
+}
+
+type NoteFile struct {
+	ID        int64  `json:"id"`
+	NoteID    int64  `json:"note_id"`
+	Key       string `json:"-"`
+	FileName  string `json:"file_name"`
+	SizeBytes int64  `json:"size_bytes"`
+	SHA256    string `json:"sha256"`
+}
+
+// DuplicateFile is a file of another note with the same content hash.
+type DuplicateFile struct {
+	NoteID    int64  `json:"note_id"`
+	NoteTitle string `json:"note_title"`
+	FileName  string `json:"file_name"`
+	SHA256    string `json:"sha256"`
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns, so user input only
+// matches literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// NoteFilter narrows the notes listing, zero values don't filter.
+type NoteFilter struct {
+	Subject  string
+	AuthorID int64
 }
 
 type NoteStore struct {
 	db *sql.DB
 }
 
-func (s *NoteStore) GetNotes(ctx context.Context, professorID int64) ([]*Note, error) {
+// GetNotes returns a page of the professor notes and the total of notes that
+// match the filters.
+func (s *NoteStore) GetNotes(ctx context.Context, professorID int64, fq PaginatedFeedQuery, filter NoteFilter) ([]*Note, int, error) {
 	query := `
-		SELECT id, content, subject, title, files_url, user_id, professor_id,
-		created_at
-		FROM notes
-		WHERE professor_id = $1
+		SELECT n.id, n.content, n.subject, n.title, n.files_url, n.user_id, n.professor_id,
+		(SELECT COUNT(*) FROM comments c WHERE c.note_id = n.id AND c.deleted_at IS NULL) AS comments_count,
+		n.created_at, COUNT(*) OVER() AS total
+		FROM notes n
+		WHERE n.professor_id = $1
+		AND ($2 = '' OR n.title ILIKE '%' || $2 || '%' ESCAPE '\')
+		AND ($3 = '' OR lower(n.subject) = lower($3))
+		AND ($4 = 0 OR n.user_id = $4)
+		AND n.created_at >= COALESCE(NULLIF($5, '')::timestamptz, '-infinity')
+		AND n.created_at <= COALESCE(NULLIF($6, '')::timestamptz, 'infinity')
+		AND ` + hiddenAuthor("n") + `
+		ORDER BY n.created_at ` + sortDirection(fq.Sort) + `, n.id ` + sortDirection(fq.Sort) + `
+		LIMIT $7 OFFSET $8
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	rows, err := s.db.QueryContext(ctx, query, professorID)
+	rows, err := s.db.QueryContext(
+		ctx,
+		query,
+		professorID,
+		escapeLike(fq.Search),
+		filter.Subject,
+		filter.AuthorID,
+		fq.Since,
+		fq.Until,
+		fq.Limit,
+		fq.Offset,
+	)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	defer rows.Close()
 	notes := []*Note{}
+	total := 0
 
 	for rows.Next() {
 		n := &Note{}
@@ -51,42 +108,317 @@ func (s *NoteStore) GetNotes(ctx context.Context, professorID int64) ([]*Note, e
 			pq.Array(&n.FilesURL),
 			&n.UserID,
 			&n.ProfessorID,
+			&n.CommentsCount,
 			&n.CreatedAt,
+			&total,
 		)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 
 		notes = append(notes, n)
 	}
 
-	return notes, nil
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// the window count is missing when the offset is past the last note
+	if len(notes) == 0 && fq.Offset > 0 {
+		total, err = s.countNotes(ctx, professorID, fq, filter)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return notes, total, nil
+}
+
+func (s *NoteStore) countNotes(ctx context.Context, professorID int64, fq PaginatedFeedQuery, filter NoteFilter) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM notes n
+		WHERE n.professor_id = $1
+		AND ($2 = '' OR n.title ILIKE '%' || $2 || '%' ESCAPE '\')
+		AND ($3 = '' OR lower(n.subject) = lower($3))
+		AND ($4 = 0 OR n.user_id = $4)
+		AND n.created_at >= COALESCE(NULLIF($5, '')::timestamptz, '-infinity')
+		AND n.created_at <= COALESCE(NULLIF($6, '')::timestamptz, 'infinity')
+		AND ` + hiddenAuthor("n") + `
+	`
+
+	var total int
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		professorID,
+		escapeLike(fq.Search),
+		filter.Subject,
+		filter.AuthorID,
+		fq.Since,
+		fq.Until,
+	).Scan(&total)
+	if err != nil {
+		return 0, err
+	}
+
+	return total, nil
 }
 
 func (s *NoteStore) Create(ctx context.Context, userID int64, n *Note) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		var totalSize int64
+		for _, f := range n.Files {
+			totalSize += f.SizeBytes
+		}
+
+		if err := reserveStorage(ctx, tx, userID, totalSize, len(n.Files)); err != nil {
+			return err
+		}
+
+		query := `
+			INSERT INTO notes (content, subject, title, files_url, user_id, professor_id) 
+			VALUES ($1, $2, $3, $4, $5, $6) 
+			RETURNING id, created_at
+		`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		err := tx.QueryRowContext(ctx, query, n.Content, n.Subject, n.Title, pq.Array(n.FilesURL), userID, n.ProfessorID).Scan(&n.ID, &n.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		n.UserID = userID
+
+		for _, f := range n.Files {
+			f.NoteID = n.ID
+			if err := s.createFile(ctx, tx, userID, f); err != nil {
+				return err
+			}
+		}
 
+		return nil
+	})
+}
+
+func (s *NoteStore) createFile(ctx context.Context, tx *sql.Tx, userID int64, f *NoteFile) error {
 	query := `
-		INSERT INTO notes (content, subject, title, files_url, user_id, professor_id) 
-		VALUES ($1, $2, $3, $4, $5, $6) 
-		RETURNING id, created_at
+		INSERT INTO note_files (note_id, user_id, file_key, file_name, size_bytes, sha256)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	err := s.db.QueryRowContext(ctx, query, n.Content, n.Subject, n.Title, pq.Array(n.FilesURL), userID, n.ProfessorID).Scan(&n.ID, &n.CreatedAt)
+	return tx.QueryRowContext(ctx, query, f.NoteID, userID, f.Key, f.FileName, f.SizeBytes, f.SHA256).Scan(&f.ID)
+}
+
+// AcquireBlob takes a reference to the stored object with the content hash
+// and returns its key. When no note uploaded the content yet, key is
+// registered as its object and upload stores it. The blob row stays locked
+// until upload returns, concurrent uploads of the same content wait for it
+// and reference the object once it exists, and a failed upload leaves no
+// blob behind. The files of the note must be acquired before Create so a
+// concurrent delete can't remove their objects.
+func (s *NoteStore) AcquireBlob(ctx context.Context, hash, key string, size int64, upload func(key string) error) (string, error) {
+	query := `
+		INSERT INTO file_blobs (sha256, file_key, size_bytes, ref_count)
+		VALUES ($1, $2, $3, 1)
+		ON CONFLICT (sha256) DO UPDATE SET ref_count = file_blobs.ref_count + 1
+		RETURNING file_key, xmax = 0
+	`
+
+	var storedKey string
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		queryCtx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		var inserted bool
+		if err := tx.QueryRowContext(queryCtx, query, hash, key, size).Scan(&storedKey, &inserted); err != nil {
+			return err
+		}
+
+		if !inserted {
+			return nil
+		}
+
+		return upload(storedKey)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return storedKey, nil
+}
+
+// ReleaseBlobs drops the references taken with AcquireBlob for a note that
+// was not created, it returns the keys of the objects no note uses anymore.
+func (s *NoteStore) ReleaseBlobs(ctx context.Context, hashes []string) ([]string, error) {
+	var keys []string
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		for _, hash := range hashes {
+			query := `
+				UPDATE file_blobs SET ref_count = GREATEST(ref_count - 1, 0)
+				WHERE sha256 = $1
+				RETURNING file_key, ref_count
+			`
+
+			var key string
+			var refCount int
+			err := tx.QueryRowContext(ctx, query, hash).Scan(&key, &refCount)
+			if err != nil {
+				switch err {
+				case sql.ErrNoRows:
+					continue
+				default:
+					return err
+				}
+			}
+
+			if refCount > 0 {
+				continue
+			}
+
+			query = `DELETE FROM file_blobs WHERE sha256 = $1 AND ref_count = 0`
+			if _, err := tx.ExecContext(ctx, query, hash); err != nil {
+				return err
+			}
+
+			keys = append(keys, key)
+		}
+
+		return nil
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return keys, nil
 }
 
+// releaseBlobs drops the references of the note files and returns the keys
+// of the objects no other note uses anymore.
+func (s *NoteStore) releaseBlobs(ctx context.Context, tx *sql.Tx, noteID int64) ([]string, error) {
+	query := `
+		WITH refs AS (
+			SELECT sha256, COUNT(*) AS total
+			FROM note_files
+			WHERE note_id = $1 AND sha256 IS NOT NULL
+			GROUP BY sha256
+		)
+		UPDATE file_blobs b
+		SET ref_count = GREATEST(b.ref_count - refs.total, 0)
+		FROM refs
+		WHERE b.sha256 = refs.sha256
+		RETURNING b.sha256, b.file_key, b.ref_count
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := tx.QueryContext(ctx, query, noteID)
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans, keys []string
+	for rows.Next() {
+		var hash, key string
+		var refCount int
+		if err := rows.Scan(&hash, &key, &refCount); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		if refCount == 0 {
+			orphans = append(orphans, hash)
+			keys = append(keys, key)
+		}
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(orphans) == 0 {
+		return nil, nil
+	}
+
+	// the note files must go before the blobs they reference
+	query = `DELETE FROM note_files WHERE note_id = $1`
+	if _, err := tx.ExecContext(ctx, query, noteID); err != nil {
+		return nil, err
+	}
+
+	query = `DELETE FROM file_blobs WHERE sha256 = ANY($1) AND ref_count = 0`
+	if _, err := tx.ExecContext(ctx, query, pq.Array(orphans)); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// FindDuplicates returns the files of the professor notes that have any of
+// the given content hashes.
+func (s *NoteStore) FindDuplicates(ctx context.Context, professorID int64, hashes []string) ([]*DuplicateFile, error) {
+	query := `
+		SELECT n.id, n.title, nf.file_name, nf.sha256
+		FROM note_files nf
+		JOIN notes n ON n.id = nf.note_id
+		WHERE n.professor_id = $1 AND nf.sha256 = ANY($2)
+		ORDER BY n.id
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, professorID, pq.Array(hashes))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var duplicates []*DuplicateFile
+	for rows.Next() {
+		d := &DuplicateFile{}
+		if err := rows.Scan(&d.NoteID, &d.NoteTitle, &d.FileName, &d.SHA256); err != nil {
+			return nil, err
+		}
+
+		duplicates = append(duplicates, d)
+	}
+
+	return duplicates, rows.Err()
+}
+
+// GetNoteByID returns the note unless its author is suspended with the
+// content hidden, it returns ErrNotFound then.
 func (s *NoteStore) GetNoteByID(ctx context.Context, noteID int64) (*Note, error) {
+	return s.getNoteByID(ctx, noteID, "AND "+hiddenAuthor("n"))
+}
+
+// GetNoteByIDWithHidden returns the note even if its author content is
+// hidden, so moderators can still delete it.
+func (s *NoteStore) GetNoteByIDWithHidden(ctx context.Context, noteID int64) (*Note, error) {
+	return s.getNoteByID(ctx, noteID, "")
+}
+
+func (s *NoteStore) getNoteByID(ctx context.Context, noteID int64, filter string) (*Note, error) {
 	query := `
-		SELECT id, content, subject, title, files_url, user_id, professor_id, created_at
-		FROM notes
-		WHERE id = $1
+		SELECT n.id, n.content, n.subject, n.title, n.files_url, n.user_id, n.professor_id,
+		(SELECT COUNT(*) FROM comments c WHERE c.note_id = n.id AND c.deleted_at IS NULL) AS comments_count,
+		n.created_at
+		FROM notes n
+		WHERE n.id = $1 ` + filter + `
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
@@ -106,6 +438,7 @@ func (s *NoteStore) GetNoteByID(ctx context.Context, noteID int64) (*Note, error
 		pq.Array(&n.FilesURL),
 		&n.UserID,
 		&n.ProfessorID,
+		&n.CommentsCount,
 		&n.CreatedAt,
 	)
 	if err != nil {
@@ -120,11 +453,44 @@ func (s *NoteStore) GetNoteByID(ctx context.Context, noteID int64) (*Note, error
 	return n, nil
 }
 
+func (s *NoteStore) GetFiles(ctx context.Context, noteID int64) ([]*NoteFile, error) {
+	query := `
+		SELECT id, note_id, file_key, file_name, size_bytes, COALESCE(sha256, '')
+		FROM note_files
+		WHERE note_id = $1
+		ORDER BY id
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, noteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []*NoteFile{}
+	for rows.Next() {
+		f := &NoteFile{}
+		if err := rows.Scan(&f.ID, &f.NoteID, &f.Key, &f.FileName, &f.SizeBytes, &f.SHA256); err != nil {
+			return nil, err
+		}
+
+		files = append(files, f)
+	}
+
+	return files, rows.Err()
+}
+
 func (s *NoteStore) GetNotesByName(ctx context.Context, fq PaginatedFeedQuery, professorID int64) ([]*Note, error) {
 	query := `
-		SELECT id, subject, title, content, files_url, professor_id, created_at
-		FROM notes 
-		WHERE professor_id = $1 AND title ILIKE '%' || $2 || '%'
+		SELECT n.id, n.subject, n.title, n.content, n.files_url, n.professor_id,
+		(SELECT COUNT(*) FROM comments c WHERE c.note_id = n.id AND c.deleted_at IS NULL) AS comments_count,
+		n.created_at
+		FROM notes n
+		WHERE n.professor_id = $1 AND n.title ILIKE '%' || $2 || '%'
+		AND ` + hiddenAuthor("n") + `
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
@@ -146,6 +512,7 @@ func (s *NoteStore) GetNotesByName(ctx context.Context, fq PaginatedFeedQuery, p
 			&n.Content,
 			pq.Array(&n.FilesURL),
 			&n.ProfessorID,
+			&n.CommentsCount,
 			&n.CreatedAt,
 		)
 		if err != nil {
@@ -158,19 +525,93 @@ func (s *NoteStore) GetNotesByName(ctx context.Context, fq PaginatedFeedQuery, p
 
 }
 
-func (s *NoteStore) Delete(ctx context.Context, noteID int64) error {
-	query := `
-		DELETE FROM notes
-		WHERE id = $1
-	`
+// Delete removes the note and gives back the storage of its files to the
+// user that uploaded them. It returns the keys of the stored objects that are
+// no longer referenced by any note so the caller can remove them.
+func (s *NoteStore) Delete(ctx context.Context, noteID int64) ([]string, error) {
+	var orphanKeys []string
 
-	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
-	defer cancel()
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			SELECT user_id, COALESCE(SUM(size_bytes), 0), COUNT(*)
+			FROM note_files
+			WHERE note_id = $1
+			GROUP BY user_id
+		`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
 
-	_, err := s.db.ExecContext(ctx, query, noteID)
+		rows, err := tx.QueryContext(ctx, query, noteID)
+		if err != nil {
+			return err
+		}
+
+		type usage struct {
+			userID int64
+			bytes  int64
+			files  int
+		}
+
+		var usages []usage
+		for rows.Next() {
+			var u usage
+			if err := rows.Scan(&u.userID, &u.bytes, &u.files); err != nil {
+				rows.Close()
+				return err
+			}
+			usages = append(usages, u)
+		}
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		// notes uploaded before the files were tracked only count their files
+		if len(usages) == 0 {
+			query = `
+				SELECT user_id, 0, COALESCE(cardinality(NULLIF(files_url, '')::text[]), 0)
+				FROM notes
+				WHERE id = $1
+			`
+
+			var u usage
+			err := tx.QueryRowContext(ctx, query, noteID).Scan(&u.userID, &u.bytes, &u.files)
+			switch err {
+			case nil:
+				usages = append(usages, u)
+			case sql.ErrNoRows:
+			default:
+				return err
+			}
+		}
+
+		for _, u := range usages {
+			if err := releaseStorage(ctx, tx, u.userID, u.bytes, u.files); err != nil {
+				return err
+			}
+		}
+
+		orphanKeys, err = s.releaseBlobs(ctx, tx, noteID)
+		if err != nil {
+			return err
+		}
+
+		query = `
+			DELETE FROM notes
+			WHERE id = $1
+		`
+
+		if _, err := tx.ExecContext(ctx, query, noteID); err != nil {
+			return err
+		}
+
+		return nil
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return orphanKeys, nil
 }
@@ -0,0 +1,133 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// NotificationNoteComments is the only category with a sender, add new
+// categories along with the code queueing their emails.
+const (
+	NotificationNoteComments = "note_comments"
+)
+
+var ErrUnknownCategory = errors.New("unknown notification category")
+
+// notificationDefaults are the preferences of a user that never changed them.
+var notificationDefaults = map[string]bool{
+	NotificationNoteComments: true,
+}
+
+// NotificationCategories lists the categories in the order they are shown.
+var NotificationCategories = []string{
+	NotificationNoteComments,
+}
+
+func IsNotificationCategory(category string) bool {
+	_, ok := notificationDefaults[category]
+	return ok
+}
+
+type NotificationPreference struct {
+	Category string `json:"category"`
+	Enabled  bool   `json:"enabled"`
+}
+
+type NotificationStore struct {
+	db *sql.DB
+}
+
+// GetPreferences returns every category, with the default value for the
+// ones the user never changed.
+func (s *NotificationStore) GetPreferences(ctx context.Context, userID int64) ([]*NotificationPreference, error) {
+	query := `SELECT category, enabled FROM notification_preferences WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	saved := map[string]bool{}
+	for rows.Next() {
+		var category string
+		var enabled bool
+		if err := rows.Scan(&category, &enabled); err != nil {
+			return nil, err
+		}
+
+		saved[category] = enabled
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	prefs := make([]*NotificationPreference, 0, len(NotificationCategories))
+	for _, category := range NotificationCategories {
+		enabled, ok := saved[category]
+		if !ok {
+			enabled = notificationDefaults[category]
+		}
+
+		prefs = append(prefs, &NotificationPreference{Category: category, Enabled: enabled})
+	}
+
+	return prefs, nil
+}
+
+func (s *NotificationStore) SetPreferences(ctx context.Context, userID int64, prefs map[string]bool) error {
+	for category := range prefs {
+		if !IsNotificationCategory(category) {
+			return ErrUnknownCategory
+		}
+	}
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			INSERT INTO notification_preferences (user_id, category, enabled)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (user_id, category) DO UPDATE
+			SET enabled = EXCLUDED.enabled, updated_at = NOW()
+		`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		for category, enabled := range prefs {
+			if _, err := tx.ExecContext(ctx, query, userID, category, enabled); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *NotificationStore) IsEnabled(ctx context.Context, userID int64, category string) (bool, error) {
+	if !IsNotificationCategory(category) {
+		return false, ErrUnknownCategory
+	}
+
+	query := `SELECT enabled FROM notification_preferences WHERE user_id = $1 AND category = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var enabled bool
+	err := s.db.QueryRowContext(ctx, query, userID, category).Scan(&enabled)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return notificationDefaults[category], nil
+		default:
+			return false, err
+		}
+	}
+
+	return enabled, nil
+}
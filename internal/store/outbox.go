@@ -0,0 +1,258 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const (
+	OutboxPending = "pending"
+	OutboxSending = "sending"
+	OutboxSent    = "sent"
+	OutboxDead    = "dead"
+	OutboxSkipped = "skipped"
+)
+
+// OutboxEmail is an email waiting to be delivered by the dispatcher. Data is
+// kept out of the JSON because it can contain tokens, and it is cleared once
+// the email is sent or skipped so the tokens don't outlive the email. Dead
+// emails keep it to be retried. Emails with a Category
+// are notifications, they are only sent if the user has the category enabled
+// and carry an unsubscribe link.
+type OutboxEmail struct {
+	ID             int64           `json:"id"`
+	Template       string          `json:"template"`
+	Locale         string          `json:"locale"`
+	UserID         *int64          `json:"user_id"`
+	Category       string          `json:"category"`
+	RecipientName  string          `json:"recipient_name"`
+	RecipientEmail string          `json:"recipient_email"`
+	Data           json.RawMessage `json:"-"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  string          `json:"next_attempt_at"`
+	LastError      string          `json:"last_error"`
+	CreatedAt      string          `json:"created_at"`
+	SentAt         *string         `json:"sent_at"`
+}
+
+// NewOutboxEmail encodes the template data so it can be stored, the template
+// is rendered in the given locale when the email is sent.
+func NewOutboxEmail(template, locale, name, email string, data any) (*OutboxEmail, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OutboxEmail{
+		Template:       template,
+		Locale:         locale,
+		RecipientName:  name,
+		RecipientEmail: email,
+		Data:           raw,
+	}, nil
+}
+
+type OutboxStore struct {
+	db *sql.DB
+}
+
+func (s *OutboxStore) Enqueue(ctx context.Context, e *OutboxEmail) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		return enqueueEmail(ctx, tx, e)
+	})
+}
+
+// enqueueEmail inserts the email in the caller transaction, so it is only
+// sent if the rest of the transaction commits.
+func enqueueEmail(ctx context.Context, tx *sql.Tx, e *OutboxEmail) error {
+	query := `
+		INSERT INTO email_outbox (template, locale, user_id, category, recipient_name, recipient_email, data)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, status, next_attempt_at, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return tx.QueryRowContext(
+		ctx,
+		query,
+		e.Template,
+		e.Locale,
+		e.UserID,
+		e.Category,
+		e.RecipientName,
+		e.RecipientEmail,
+		e.Data,
+	).Scan(
+		&e.ID,
+		&e.Status,
+		&e.NextAttemptAt,
+		&e.CreatedAt,
+	)
+}
+
+// Claim locks a batch of due emails for the lease duration. Emails left in
+// sending by a dispatcher that died are claimed again once the lease expires.
+func (s *OutboxStore) Claim(ctx context.Context, limit int, lease time.Duration) ([]*OutboxEmail, error) {
+	query := `
+		UPDATE email_outbox
+		SET status = 'sending', attempts = attempts + 1, next_attempt_at = $2
+		WHERE id IN (
+			SELECT id FROM email_outbox
+			WHERE status IN ('pending', 'sending') AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, template, locale, user_id, category, recipient_name, recipient_email, data, status,
+		attempts, next_attempt_at, last_error, created_at, sent_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, limit, time.Now().Add(lease))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanOutboxEmails(rows)
+}
+
+// MarkSent records the delivery and clears the template data.
+func (s *OutboxStore) MarkSent(ctx context.Context, id int64) error {
+	query := `
+		UPDATE email_outbox SET status = 'sent', sent_at = NOW(), last_error = '', data = '{}'
+		WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, id)
+	return err
+}
+
+// MarkFailed schedules another attempt, or dead letters the email when
+// nextAttempt is nil.
+func (s *OutboxStore) MarkFailed(ctx context.Context, id int64, sendErr string, nextAttempt *time.Time) error {
+	query := `
+		UPDATE email_outbox SET status = 'pending', last_error = $2, next_attempt_at = $3
+		WHERE id = $1
+	`
+	args := []any{id, sendErr, nextAttempt}
+
+	if nextAttempt == nil {
+		query = `
+			UPDATE email_outbox SET status = 'dead', last_error = $2
+			WHERE id = $1
+		`
+		args = args[:2]
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// MarkSkipped drops the email without sending it, e.g. when the user
+// unsubscribed after it was queued, and clears the template data.
+func (s *OutboxStore) MarkSkipped(ctx context.Context, id int64, reason string) error {
+	query := `
+		UPDATE email_outbox SET status = 'skipped', last_error = $2, data = '{}'
+		WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, id, reason)
+	return err
+}
+
+// Retry puts a dead lettered email back in the queue.
+func (s *OutboxStore) Retry(ctx context.Context, id int64) error {
+	query := `
+		UPDATE email_outbox SET status = 'pending', attempts = 0, next_attempt_at = NOW()
+		WHERE id = $1 AND status = 'dead'
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// List returns the emails with the given status, or all of them when status
+// is empty.
+func (s *OutboxStore) List(ctx context.Context, status string, fq PaginatedFeedQuery) ([]*OutboxEmail, error) {
+	query := `
+		SELECT id, template, locale, user_id, category, recipient_name, recipient_email, data, status,
+		attempts, next_attempt_at, last_error, created_at, sent_at
+		FROM email_outbox
+		WHERE ($1 = '' OR status = $1)
+		ORDER BY created_at ` + sortDirection(fq.Sort) + `, id ` + sortDirection(fq.Sort) + `
+		LIMIT $2 OFFSET $3
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, status, fq.Limit, fq.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanOutboxEmails(rows)
+}
+
+func scanOutboxEmails(rows *sql.Rows) ([]*OutboxEmail, error) {
+	emails := []*OutboxEmail{}
+	for rows.Next() {
+		e := &OutboxEmail{}
+		if err := rows.Scan(
+			&e.ID,
+			&e.Template,
+			&e.Locale,
+			&e.UserID,
+			&e.Category,
+			&e.RecipientName,
+			&e.RecipientEmail,
+			&e.Data,
+			&e.Status,
+			&e.Attempts,
+			&e.NextAttemptAt,
+			&e.LastError,
+			&e.CreatedAt,
+			&e.SentAt,
+		); err != nil {
+			return nil, err
+		}
+
+		emails = append(emails, e)
+	}
+
+	return emails, rows.Err()
+}
@@ -1,7 +1,7 @@
 package store
 
 import (
-	"log"
+	"fmt"
 	"net/http"
 	"strconv"
 	"time"
@@ -30,7 +30,7 @@ func (fq PaginatedFeedQuery) Parse(r *http.Request) (PaginatedFeedQuery, error)
 	}
 
 	offset := qs.Get("offset")
-	if limit != "" {
+	if offset != "" {
 		o, err := strconv.Atoi(offset)
 		if err != nil {
 			return fq, nil
@@ -51,22 +51,40 @@ func (fq PaginatedFeedQuery) Parse(r *http.Request) (PaginatedFeedQuery, error)
 
 	since := qs.Get("since")
 	if since != "" {
-		fq.Since = parseTime(since)
+		t, err := parseTime(since)
+		if err != nil {
+			return fq, err
+		}
+		fq.Since = t
 	}
 
 	until := qs.Get("until")
 	if until != "" {
-		fq.Until = parseTime(until)
+		t, err := parseTime(until)
+		if err != nil {
+			return fq, err
+		}
+		fq.Until = t
 	}
 
 	return fq, nil
 }
 
-func parseTime(s string) string {
+func parseTime(s string) (string, error) {
 	t, err := time.Parse(time.DateTime, s)
 	if err != nil {
-		log.Fatalf("invalid timestamp: %s", err)
+		return "", fmt.Errorf("invalid timestamp: %w", err)
+	}
+
+	return t.Format(time.DateTime), nil
+}
+
+// sortDirection maps the feed sort to a SQL keyword so it can be safely
+// concatenated into ORDER BY clauses.
+func sortDirection(sort string) string {
+	if sort == "asc" {
+		return "ASC"
 	}
 
-	return t.Format(time.DateTime)
+	return "DESC"
 }
@@ -82,7 +82,7 @@ func (s *ProfessorStore) GetProfessorsByName(ctx context.Context, name string) (
 		p.school_id,
 		COUNT(r.id) AS total_reviews
 		FROM professor p
-		LEFT JOIN reviews r ON r.professor_id = p.id
+		LEFT JOIN reviews r ON r.professor_id = p.id AND ` + hiddenAuthor("r") + `
 		WHERE p.name ILIKE '%' || $1 || '%'
 		GROUP BY p.id, p.name, p.subject, p.school_id
 	`
@@ -0,0 +1,138 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+type StorageUsage struct {
+	UserID         int64 `json:"user_id"`
+	BytesUsed      int64 `json:"bytes_used"`
+	FilesCount     int   `json:"files_count"`
+	QuotaBytes     int64 `json:"quota_bytes"`
+	RemainingBytes int64 `json:"remaining_bytes"`
+	IsOverride     bool  `json:"is_override"`
+}
+
+type QuotaStore struct {
+	db *sql.DB
+}
+
+func (s *QuotaStore) GetUsage(ctx context.Context, userID int64) (*StorageUsage, error) {
+	query := `
+		SELECT u.id, COALESCE(su.bytes_used, 0), COALESCE(su.files_count, 0),
+		COALESCE(u.storage_quota_bytes, r.storage_quota_bytes),
+		u.storage_quota_bytes IS NOT NULL
+		FROM users u
+		JOIN roles r ON r.id = u.role_id
+		LEFT JOIN storage_usage su ON su.user_id = u.id
+		WHERE u.id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	usage := &StorageUsage{}
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(
+		&usage.UserID,
+		&usage.BytesUsed,
+		&usage.FilesCount,
+		&usage.QuotaBytes,
+		&usage.IsOverride,
+	)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	usage.RemainingBytes = max(usage.QuotaBytes-usage.BytesUsed, 0)
+
+	return usage, nil
+}
+
+// SetUserQuota overrides the quota of the user's role, a nil quota removes
+// the override.
+func (s *QuotaStore) SetUserQuota(ctx context.Context, userID int64, quotaBytes *int64) error {
+	query := `UPDATE users SET storage_quota_bytes = $1 WHERE id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, quotaBytes, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// reserveStorage adds the bytes to the user usage only if the result stays
+// within the user quota, so concurrent uploads can't go over it.
+func reserveStorage(ctx context.Context, tx *sql.Tx, userID, bytes int64, files int) error {
+	query := `
+		INSERT INTO storage_usage (user_id) VALUES ($1)
+		ON CONFLICT (user_id) DO NOTHING
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+		return err
+	}
+
+	query = `
+		UPDATE storage_usage su
+		SET bytes_used = su.bytes_used + $2, files_count = su.files_count + $3, updated_at = NOW()
+		FROM users u
+		JOIN roles r ON r.id = u.role_id
+		WHERE su.user_id = $1 AND u.id = su.user_id
+		AND su.bytes_used + $2 <= COALESCE(u.storage_quota_bytes, r.storage_quota_bytes)
+	`
+
+	res, err := tx.ExecContext(ctx, query, userID, bytes, files)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrQuotaExceeded
+	}
+
+	return nil
+}
+
+func releaseStorage(ctx context.Context, tx *sql.Tx, userID, bytes int64, files int) error {
+	query := `
+		UPDATE storage_usage
+		SET bytes_used = GREATEST(bytes_used - $2, 0), files_count = GREATEST(files_count - $3, 0), updated_at = NOW()
+		WHERE user_id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, userID, bytes, files)
+	return err
+}
@@ -86,9 +86,9 @@ func (s *ReviewStore) CreateReview(ctx context.Context, userID int64, r *Review)
 
 func (s *ReviewStore) GetTagsFromProfessor(ctx context.Context, professorID int64) ([]string, error) {
 	query := `
-	SELECT DISTINCT unnest(tags) 
-	FROM reviews 
-	WHERE professor_id = $1
+	SELECT DISTINCT unnest(r.tags)
+	FROM reviews r
+	WHERE r.professor_id = $1 AND ` + hiddenAuthor("r") + `
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
@@ -118,7 +118,7 @@ func (s *ReviewStore) GetProfessorReviews(ctx context.Context, professorID int64
 	SELECT r.id, r.subject, r.difficulty, r.text , r.created_at, r.rating, r.would_take_again, r.tags
 	FROM reviews r 
 	JOIN professor p ON p.id = r.professor_id
-	WHERE p.id = $1
+	WHERE p.id = $1 AND ` + hiddenAuthor("r") + `
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
@@ -153,3 +153,57 @@ func (s *ReviewStore) GetProfessorReviews(ctx context.Context, professorID int64
 
 	return reviews, nil
 }
+
+// Delete removes a review and returns it, so moderators can audit what they
+// removed.
+func (s *ReviewStore) Delete(ctx context.Context, reviewID int64) (*Review, error) {
+	query := `
+		DELETE FROM reviews WHERE id = $1
+		RETURNING id, subject, difficulty, text, created_at, user_id, rating, would_take_again, professor_id, tags
+	`
+
+	return s.delete(ctx, query, reviewID)
+}
+
+// DeleteInSchool removes a review only if its professor teaches at the
+// school, it returns ErrNotFound otherwise.
+func (s *ReviewStore) DeleteInSchool(ctx context.Context, reviewID, schoolID int64) (*Review, error) {
+	query := `
+		DELETE FROM reviews r
+		USING professor p
+		WHERE r.id = $1 AND p.id = r.professor_id AND p.school_id = $2
+		RETURNING r.id, r.subject, r.difficulty, r.text, r.created_at, r.user_id, r.rating, r.would_take_again,
+		r.professor_id, r.tags
+	`
+
+	return s.delete(ctx, query, reviewID, schoolID)
+}
+
+func (s *ReviewStore) delete(ctx context.Context, query string, args ...any) (*Review, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	review := &Review{}
+	err := s.db.QueryRowContext(ctx, query, args...).Scan(
+		&review.ID,
+		&review.Subject,
+		&review.Difficulty,
+		&review.Text,
+		&review.CreatedAt,
+		&review.UserID,
+		&review.Rating,
+		&review.WouldTakeAgain,
+		&review.ProfessorID,
+		pq.Array(&review.Tags),
+	)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return review, nil
+}
@@ -3,36 +3,175 @@ package store
 import (
 	"context"
 	"database/sql"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	PermissionSchoolCreate     = "school:create"
+	PermissionProfessorCreate  = "professor:create"
+	PermissionReviewModerate   = "review:moderate"
+	PermissionNoteDeleteAny    = "note:delete:any"
+	PermissionCommentDeleteAny = "comment:delete:any"
+	PermissionUserManage       = "user:manage"
+	PermissionMailManage       = "mail:manage"
+	PermissionAuditRead        = "audit:read"
 )
 
+// roleCacheTTL is how long a role read from the database is reused, roles
+// only change with migrations.
+const roleCacheTTL = 5 * time.Minute
+
 type Role struct {
-	ID          int64  `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Level       int    `json:"level"`
+	ID          int64    `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Level       int      `json:"level"`
+	Permissions []string `json:"permissions"`
+}
+
+func (r *Role) HasPermission(permission string) bool {
+	return slices.Contains(r.Permissions, permission)
 }
 
 type RoleStore struct {
 	db *sql.DB
 }
 
+const roleQuery = `
+	SELECT r.id, r.name, COALESCE(r.description, ''), r.level,
+	COALESCE(ARRAY_AGG(p.name ORDER BY p.name) FILTER (WHERE p.name IS NOT NULL), '{}')
+	FROM roles r
+	LEFT JOIN role_permissions rp ON rp.role_id = r.id
+	LEFT JOIN permissions p ON p.id = rp.permission_id
+`
+
 func (s *RoleStore) GetRoleByName(ctx context.Context, slug string) (*Role, error) {
-	role := &Role{}
+	return s.get(ctx, roleQuery+`WHERE r.name = $1 GROUP BY r.id`, slug)
+}
 
-	query := `SELECT id, name, description, level FROM roles WHERE name = $1`
+func (s *RoleStore) GetRoleByID(ctx context.Context, roleID int64) (*Role, error) {
+	return s.get(ctx, roleQuery+`WHERE r.id = $1 GROUP BY r.id`, roleID)
+}
 
+func (s *RoleStore) List(ctx context.Context) ([]*Role, error) {
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	err := s.db.QueryRowContext(ctx, query, slug).Scan(
+	rows, err := s.db.QueryContext(ctx, roleQuery+`GROUP BY r.id ORDER BY r.level`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+	for rows.Next() {
+		role := &Role{}
+		if err := rows.Scan(
+			&role.ID,
+			&role.Name,
+			&role.Description,
+			&role.Level,
+			pq.Array(&role.Permissions),
+		); err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+func (s *RoleStore) get(ctx context.Context, query string, arg any) (*Role, error) {
+	role := &Role{}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(ctx, query, arg).Scan(
 		&role.ID,
 		&role.Name,
 		&role.Description,
 		&role.Level,
+		pq.Array(&role.Permissions),
 	)
 	if err != nil {
-		return nil, err
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
 	}
 
 	return role, nil
 }
+
+// CachedRoleStore keeps the roles in memory so the permission checks of
+// every request don't query them.
+type CachedRoleStore struct {
+	store *RoleStore
+	ttl   time.Duration
+
+	mu      sync.RWMutex
+	roles   []*Role
+	expires time.Time
+}
+
+func NewCachedRoleStore(store *RoleStore, ttl time.Duration) *CachedRoleStore {
+	return &CachedRoleStore{store: store, ttl: ttl}
+}
+
+func (s *CachedRoleStore) GetRoleByName(ctx context.Context, name string) (*Role, error) {
+	return s.find(ctx, func(r *Role) bool { return r.Name == name })
+}
+
+func (s *CachedRoleStore) GetRoleByID(ctx context.Context, roleID int64) (*Role, error) {
+	return s.find(ctx, func(r *Role) bool { return r.ID == roleID })
+}
+
+func (s *CachedRoleStore) List(ctx context.Context) ([]*Role, error) {
+	return s.load(ctx)
+}
+
+func (s *CachedRoleStore) find(ctx context.Context, match func(*Role) bool) (*Role, error) {
+	roles, err := s.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, role := range roles {
+		if match(role) {
+			return role, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+// load returns the cached roles, reading them again once they expired.
+func (s *CachedRoleStore) load(ctx context.Context) ([]*Role, error) {
+	s.mu.RLock()
+	roles, expires := s.roles, s.expires
+	s.mu.RUnlock()
+
+	if roles != nil && time.Now().Before(expires) {
+		return roles, nil
+	}
+
+	roles, err := s.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.roles = roles
+	s.expires = time.Now().Add(s.ttl)
+	s.mu.Unlock()
+
+	return roles, nil
+}
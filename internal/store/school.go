@@ -224,7 +224,7 @@ func (s *SchoolStore) getReviewsPerProfessor(ctx context.Context, schoolID int64
 	query := `
 	SELECT p.id, p.name, COUNT(r.id) AS review_count, p.subject
 	FROM professor p
-	LEFT JOIN reviews r ON r.professor_id = p.id
+	LEFT JOIN reviews r ON r.professor_id = p.id AND ` + hiddenAuthor("r") + `
 	WHERE p.school_id = $1
 	GROUP BY p.id, p.name
 	LIMIT $2 OFFSET $3
@@ -0,0 +1,155 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// SchoolMembership gives a user a role at a single school, like a campus
+// representative that manages the professors of the school.
+type SchoolMembership struct {
+	UserID    int64  `json:"user_id"`
+	Username  string `json:"username"`
+	SchoolID  int64  `json:"school_id"`
+	Role      string `json:"role"`
+	CreatedAt string `json:"created_at"`
+}
+
+type SchoolMembershipStore struct {
+	db *sql.DB
+}
+
+// GetRoleID returns the role of the user at the school, it returns
+// ErrNotFound if the user is not a member.
+func (s *SchoolMembershipStore) GetRoleID(ctx context.Context, userID, schoolID int64) (int64, error) {
+	query := `SELECT role_id FROM school_memberships WHERE user_id = $1 AND school_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var roleID int64
+	err := s.db.QueryRowContext(ctx, query, userID, schoolID).Scan(&roleID)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return 0, ErrNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return roleID, nil
+}
+
+func (s *SchoolMembershipStore) ListBySchool(ctx context.Context, schoolID int64) ([]*SchoolMembership, error) {
+	query := `
+		SELECT m.user_id, u.username, m.school_id, r.name, m.created_at
+		FROM school_memberships m
+		JOIN users u ON u.id = m.user_id
+		JOIN roles r ON r.id = m.role_id
+		WHERE m.school_id = $1
+		ORDER BY m.created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, schoolID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	memberships := []*SchoolMembership{}
+	for rows.Next() {
+		m := &SchoolMembership{}
+		if err := rows.Scan(
+			&m.UserID,
+			&m.Username,
+			&m.SchoolID,
+			&m.Role,
+			&m.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		memberships = append(memberships, m)
+	}
+
+	return memberships, rows.Err()
+}
+
+// Set gives the user the role at the school, replacing the previous one.
+func (s *SchoolMembershipStore) Set(ctx context.Context, userID, schoolID, roleID int64) error {
+	query := `
+		INSERT INTO school_memberships (user_id, school_id, role_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, school_id) DO UPDATE SET role_id = EXCLUDED.role_id
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, userID, schoolID, roleID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: insert or update on table "school_memberships" violates foreign key constraint "school_memberships_school_id_fkey"`:
+			return ErrInvalidSchool
+		case err.Error() == `pq: insert or update on table "school_memberships" violates foreign key constraint "school_memberships_user_id_fkey"`:
+			return ErrNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *SchoolMembershipStore) Remove(ctx context.Context, userID, schoolID int64) error {
+	query := `DELETE FROM school_memberships WHERE user_id = $1 AND school_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, schoolID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// ListRoleIDsByUser returns the roles the user has at any school.
+func (s *SchoolMembershipStore) ListRoleIDsByUser(ctx context.Context, userID int64) ([]int64, error) {
+	query := `SELECT DISTINCT role_id FROM school_memberships WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roleIDs := []int64{}
+	for rows.Next() {
+		var roleID int64
+		if err := rows.Scan(&roleID); err != nil {
+			return nil, err
+		}
+
+		roleIDs = append(roleIDs, roleID)
+	}
+
+	return roleIDs, rows.Err()
+}
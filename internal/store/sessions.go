@@ -0,0 +1,326 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/gob"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+const (
+	// sessionCookieAge is the lifetime of sessions whose cookie lasts until
+	// the browser closes.
+	sessionCookieAge = 24 * time.Hour
+	maxUserAgentLen  = 512
+)
+
+// Session is an active login of the user, the token in the cookie is never
+// returned.
+type Session struct {
+	ID         int64  `json:"id"`
+	UserAgent  string `json:"user_agent"`
+	IP         string `json:"ip"`
+	CreatedAt  string `json:"created_at"`
+	LastSeenAt string `json:"last_seen_at"`
+	ExpiresAt  string `json:"expires_at"`
+	Current    bool   `json:"current"`
+}
+
+// SessionStore is a sessions.Store that keeps the values in Postgres, the
+// cookie only holds a random session token stored hashed.
+type SessionStore struct {
+	db      *sql.DB
+	Options *sessions.Options
+}
+
+func NewSessionStore(db *sql.DB, options sessions.Options) *SessionStore {
+	return &SessionStore{db: db, Options: &options}
+}
+
+func (s *SessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns the session of the cookie, or a new one when the cookie is
+// missing, revoked or expired.
+func (s *SessionStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil || cookie.Value == "" {
+		return session, nil
+	}
+
+	values, err := s.load(r.Context(), name, cookie.Value)
+	if err != nil {
+		if err == ErrNotFound {
+			return session, nil
+		}
+		return session, err
+	}
+
+	session.ID = cookie.Value
+	session.Values = values
+	session.IsNew = false
+
+	return session, nil
+}
+
+// Save stores the session and refreshes its expiry, a negative MaxAge
+// deletes it.
+func (s *SessionStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.delete(r.Context(), session.ID); err != nil {
+				return err
+			}
+		}
+
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		token, err := newSessionToken()
+		if err != nil {
+			return err
+		}
+		session.ID = token
+	}
+
+	if err := s.save(r, session); err != nil {
+		return err
+	}
+
+	http.SetCookie(w, sessions.NewCookie(session.Name(), session.ID, session.Options))
+	return nil
+}
+
+func (s *SessionStore) load(ctx context.Context, name, token string) (map[interface{}]interface{}, error) {
+	query := `
+		UPDATE sessions SET last_seen_at = NOW()
+		WHERE token_hash = $1 AND name = $2 AND expires_at > NOW()
+		RETURNING data
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var data []byte
+	err := s.db.QueryRowContext(ctx, query, hashSessionToken(token), name).Scan(&data)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	values := map[interface{}]interface{}{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&values); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+func (s *SessionStore) save(r *http.Request, session *sessions.Session) error {
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(session.Values); err != nil {
+		return err
+	}
+
+	age := time.Duration(session.Options.MaxAge) * time.Second
+	if age == 0 {
+		age = sessionCookieAge
+	}
+
+	// the user of the session, so it can be listed and revoked
+	var userID *int64
+	if id, ok := session.Values["userID"].(int64); ok {
+		userID = &id
+	}
+
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLen {
+		userAgent = userAgent[:maxUserAgentLen]
+	}
+
+	query := `
+		INSERT INTO sessions (token_hash, name, user_id, data, user_agent, ip, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (token_hash) DO UPDATE
+		SET user_id = EXCLUDED.user_id, data = EXCLUDED.data, user_agent = EXCLUDED.user_agent,
+		ip = EXCLUDED.ip, expires_at = EXCLUDED.expires_at, last_seen_at = NOW()
+	`
+
+	ctx, cancel := context.WithTimeout(r.Context(), QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(
+		ctx,
+		query,
+		hashSessionToken(session.ID),
+		session.Name(),
+		userID,
+		data.Bytes(),
+		userAgent,
+		remoteIP(r),
+		time.Now().Add(age),
+	)
+	return err
+}
+
+func (s *SessionStore) delete(ctx context.Context, token string) error {
+	query := `DELETE FROM sessions WHERE token_hash = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, hashSessionToken(token))
+	return err
+}
+
+// revokeSessions deletes every session of the user in the caller
+// transaction, used wherever the tokens of the user are invalidated.
+func revokeSessions(ctx context.Context, tx *sql.Tx, userID int64) error {
+	query := `DELETE FROM sessions WHERE user_id = $1`
+
+	_, err := tx.ExecContext(ctx, query, userID)
+	return err
+}
+
+// Renew deletes the stored session and clears its values, the next Save
+// issues a new token. Logins renew the session so a token planted in the
+// browser before the login never gets authenticated.
+func (s *SessionStore) Renew(ctx context.Context, session *sessions.Session) error {
+	if session.ID != "" {
+		if err := s.delete(ctx, session.ID); err != nil {
+			return err
+		}
+	}
+
+	session.ID = ""
+	session.Values = map[interface{}]interface{}{}
+	session.IsNew = true
+
+	return nil
+}
+
+// ListByUser returns the active sessions of the user, currentToken marks
+// the session of the request.
+func (s *SessionStore) ListByUser(ctx context.Context, userID int64, currentToken string) ([]*Session, error) {
+	query := `
+		SELECT id, token_hash, user_agent, ip, created_at, last_seen_at, expires_at
+		FROM sessions
+		WHERE user_id = $1 AND expires_at > NOW()
+		ORDER BY last_seen_at DESC
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	current := hashSessionToken(currentToken)
+
+	list := []*Session{}
+	for rows.Next() {
+		session := &Session{}
+		var tokenHash []byte
+		if err := rows.Scan(
+			&session.ID,
+			&tokenHash,
+			&session.UserAgent,
+			&session.IP,
+			&session.CreatedAt,
+			&session.LastSeenAt,
+			&session.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+
+		session.Current = currentToken != "" && bytes.Equal(tokenHash, current)
+		list = append(list, session)
+	}
+
+	return list, rows.Err()
+}
+
+// Revoke deletes a session of the user, the cookie stops working on the
+// next request.
+func (s *SessionStore) Revoke(ctx context.Context, userID, sessionID int64) error {
+	query := `DELETE FROM sessions WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, sessionID, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// DeleteExpired removes the expired sessions and returns how many.
+func (s *SessionStore) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `DELETE FROM sessions WHERE expires_at <= NOW()`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func newSessionToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashSessionToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+func remoteIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+
+	return r.RemoteAddr
+}
@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"errors"
 	"time"
+
+	"github.com/gorilla/sessions"
 )
 
 var (
@@ -16,11 +18,18 @@ var (
 type Storage struct {
 	Users interface {
 		GetUserByEmail(ctx context.Context, email string) (*User, error)
-		CreateAndInvite(ctx context.Context, user *User, token string, exp time.Duration) error
+		CreateAndInvite(ctx context.Context, user *User, token string, exp time.Duration, welcome *OutboxEmail) error
 		Delete(ctx context.Context, id int64) error
 		GetUserByID(ctx context.Context, id int64) (*User, error)
 		Activate(ctx context.Context, token string) error
-		CreateOrUpdateUser(ctx context.Context, user *User) error
+		CreateWithIdentity(ctx context.Context, user *User, identity *Identity) error
+		SetRole(ctx context.Context, userID, roleID int64) error
+		UpdateProfile(ctx context.Context, user *User) error
+		UpdatePassword(ctx context.Context, user *User) error
+		CreateEmailChangeRequest(ctx context.Context, userID int64, newEmail, token string, exp time.Duration, emails ...*OutboxEmail) error
+		ConfirmEmailChange(ctx context.Context, token string) (*User, error)
+		RenewInvitation(ctx context.Context, email, token string, exp time.Duration) (*User, error)
+		DeleteUnactivated(ctx context.Context, retention time.Duration) (int64, error)
 	}
 	Professors interface {
 		Create(ctx context.Context, professor *Professor) error
@@ -37,29 +46,140 @@ type Storage struct {
 	}
 	Roles interface {
 		GetRoleByName(ctx context.Context, name string) (*Role, error)
+		GetRoleByID(ctx context.Context, roleID int64) (*Role, error)
+		List(ctx context.Context) ([]*Role, error)
 	}
 	Reviews interface {
 		CreateReview(ctx context.Context, userID int64, r *Review) error
 		GetProfessorReviews(ctx context.Context, professorID int64) ([]*Review, error)
 		GetTagsFromProfessor(ctx context.Context, professorID int64) ([]string, error)
+		Delete(ctx context.Context, reviewID int64) (*Review, error)
+		DeleteInSchool(ctx context.Context, reviewID, schoolID int64) (*Review, error)
 	}
 	Notes interface {
 		Create(ctx context.Context, userID int64, note *Note) error
 		GetNoteByID(ctx context.Context, noteID int64) (*Note, error)
-		Delete(ctx context.Context, noteID int64) error
+		GetNoteByIDWithHidden(ctx context.Context, noteID int64) (*Note, error)
+		Delete(ctx context.Context, noteID int64) ([]string, error)
 		GetNotesByName(ctx context.Context, fq PaginatedFeedQuery, professorID int64) ([]*Note, error)
-		GetNotes(ctx context.Context, professorID int64) ([]*Note, error)
+		GetNotes(ctx context.Context, professorID int64, fq PaginatedFeedQuery, filter NoteFilter) ([]*Note, int, error)
+		GetFiles(ctx context.Context, noteID int64) ([]*NoteFile, error)
+		AcquireBlob(ctx context.Context, hash, key string, size int64, upload func(key string) error) (string, error)
+		ReleaseBlobs(ctx context.Context, hashes []string) ([]string, error)
+		FindDuplicates(ctx context.Context, professorID int64, hashes []string) ([]*DuplicateFile, error)
+	}
+	Quotas interface {
+		GetUsage(ctx context.Context, userID int64) (*StorageUsage, error)
+		SetUserQuota(ctx context.Context, userID int64, quotaBytes *int64) error
+	}
+	Outbox interface {
+		Enqueue(ctx context.Context, email *OutboxEmail) error
+		Claim(ctx context.Context, limit int, lease time.Duration) ([]*OutboxEmail, error)
+		MarkSent(ctx context.Context, id int64) error
+		MarkFailed(ctx context.Context, id int64, sendErr string, nextAttempt *time.Time) error
+		MarkSkipped(ctx context.Context, id int64, reason string) error
+		Retry(ctx context.Context, id int64) error
+		List(ctx context.Context, status string, fq PaginatedFeedQuery) ([]*OutboxEmail, error)
+	}
+	Comments interface {
+		Create(ctx context.Context, comment *Comment) error
+		GetByID(ctx context.Context, commentID int64) (*Comment, error)
+		GetByNoteID(ctx context.Context, noteID int64, fq PaginatedFeedQuery) ([]*Comment, error)
+		GetReplies(ctx context.Context, commentID int64, fq PaginatedFeedQuery) ([]*Comment, error)
+		Update(ctx context.Context, comment *Comment) error
+		Delete(ctx context.Context, commentID int64) error
+	}
+	Notifications interface {
+		GetPreferences(ctx context.Context, userID int64) ([]*NotificationPreference, error)
+		SetPreferences(ctx context.Context, userID int64, prefs map[string]bool) error
+		IsEnabled(ctx context.Context, userID int64, category string) (bool, error)
+	}
+	Suppressions interface {
+		Record(ctx context.Context, email, reason, detail, provider string, occurredAt time.Time) error
+		IsSuppressed(ctx context.Context, email string) (bool, error)
+		List(ctx context.Context, reason string, fq PaginatedFeedQuery) ([]*EmailSuppression, error)
+		Delete(ctx context.Context, email string) error
+	}
+	MFA interface {
+		Get(ctx context.Context, userID int64) (*MFASettings, error)
+		SetPendingSecret(ctx context.Context, userID int64, secret string) error
+		Enable(ctx context.Context, userID, step int64, codeHashes []string) error
+		Disable(ctx context.Context, userID int64) error
+		UseStep(ctx context.Context, userID, step int64) error
+		UseRecoveryCode(ctx context.Context, userID int64, codeHash string) error
+		ReplaceRecoveryCodes(ctx context.Context, userID int64, codeHashes []string) error
+		CountRecoveryCodes(ctx context.Context, userID int64) (int, error)
+	}
+	LoginThrottles interface {
+		Get(ctx context.Context, scope, key string) (*LoginThrottle, error)
+		RecordFailure(ctx context.Context, scope, key string, window time.Duration) (*LoginThrottle, error)
+		Block(ctx context.Context, scope, key string, until time.Time, lockout bool) error
+		Reset(ctx context.Context, scope, key string) error
+	}
+	Identities interface {
+		GetByProvider(ctx context.Context, provider, providerUserID string) (*Identity, error)
+		ListByUser(ctx context.Context, userID int64) ([]*Identity, error)
+		Link(ctx context.Context, identity *Identity) error
+		TouchLogin(ctx context.Context, identity *Identity) error
+		Unlink(ctx context.Context, userID int64, provider string) error
+	}
+	MagicLinks interface {
+		Create(ctx context.Context, userID int64, token string, exp time.Duration, email *OutboxEmail) error
+		Consume(ctx context.Context, token string) (int64, error)
+	}
+	SchoolMemberships interface {
+		GetRoleID(ctx context.Context, userID, schoolID int64) (int64, error)
+		ListBySchool(ctx context.Context, schoolID int64) ([]*SchoolMembership, error)
+		Set(ctx context.Context, userID, schoolID, roleID int64) error
+		Remove(ctx context.Context, userID, schoolID int64) error
+		ListRoleIDsByUser(ctx context.Context, userID int64) ([]int64, error)
+	}
+	APIKeys interface {
+		Create(ctx context.Context, key *APIKey, keyHash string) error
+		GetByHash(ctx context.Context, keyHash string) (*APIKey, error)
+		ListByUser(ctx context.Context, userID int64) ([]*APIKey, error)
+		Revoke(ctx context.Context, userID, keyID int64) error
+	}
+	Suspensions interface {
+		Create(ctx context.Context, suspension *Suspension, email *OutboxEmail) error
+		GetActive(ctx context.Context, userID int64) (*Suspension, error)
+		ListByUser(ctx context.Context, userID int64) ([]*Suspension, error)
+		Lift(ctx context.Context, userID, liftedBy int64) error
+	}
+	Sessions interface {
+		ListByUser(ctx context.Context, userID int64, currentToken string) ([]*Session, error)
+		Revoke(ctx context.Context, userID, sessionID int64) error
+		Renew(ctx context.Context, session *sessions.Session) error
+		DeleteExpired(ctx context.Context) (int64, error)
+	}
+	Audit interface {
+		Record(ctx context.Context, entry *AuditEntry) error
+		List(ctx context.Context, action string, fq PaginatedFeedQuery) ([]*AuditEntry, error)
 	}
 }
 
 func NewPostgresStorage(db *sql.DB) Storage {
 	return Storage{
-		Users:      &UserStore{db},
-		Professors: &ProfessorStore{db},
-		Roles:      &RoleStore{db},
-		Schools:    &SchoolStore{db},
-		Reviews:    &ReviewStore{db},
-		Notes:      &NoteStore{db},
+		Users:             &UserStore{db},
+		Professors:        &ProfessorStore{db},
+		Roles:             NewCachedRoleStore(&RoleStore{db}, roleCacheTTL),
+		Schools:           &SchoolStore{db},
+		Reviews:           &ReviewStore{db},
+		Notes:             &NoteStore{db},
+		Comments:          &CommentStore{db},
+		Quotas:            &QuotaStore{db},
+		Outbox:            &OutboxStore{db},
+		Notifications:     &NotificationStore{db},
+		Suppressions:      &SuppressionStore{db},
+		MFA:               &MFAStore{db},
+		LoginThrottles:    &LoginThrottleStore{db},
+		Audit:             &AuditStore{db},
+		Identities:        &IdentityStore{db},
+		Sessions:          &SessionStore{db: db},
+		MagicLinks:        &MagicLinkStore{db},
+		SchoolMemberships: &SchoolMembershipStore{db},
+		APIKeys:           &APIKeyStore{db},
+		Suspensions:       &SuspensionStore{db},
 	}
 }
 
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// EmailSuppression is an address that hard bounced or complained, nothing is
+// sent to it until an admin lifts the suppression.
+type EmailSuppression struct {
+	Email       string `json:"email"`
+	UserID      *int64 `json:"user_id"`
+	Reason      string `json:"reason"`
+	Detail      string `json:"detail"`
+	Provider    string `json:"provider"`
+	EventsCount int    `json:"events_count"`
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+type SuppressionStore struct {
+	db *sql.DB
+}
+
+// Record suppresses the address, linking it to the user with that email. A
+// complaint replaces a previous bounce but not the other way around.
+func (s *SuppressionStore) Record(ctx context.Context, email, reason, detail, provider string, occurredAt time.Time) error {
+	query := `
+		INSERT INTO email_suppressions (email, user_id, reason, detail, provider, created_at, updated_at)
+		VALUES ($1, (SELECT id FROM users WHERE email = $1), $2, $3, $4, $5, $5)
+		ON CONFLICT (email) DO UPDATE
+		SET events_count = email_suppressions.events_count + 1,
+			reason = CASE WHEN EXCLUDED.reason = 'complaint' THEN 'complaint' ELSE email_suppressions.reason END,
+			detail = EXCLUDED.detail,
+			provider = EXCLUDED.provider,
+			user_id = COALESCE(EXCLUDED.user_id, email_suppressions.user_id),
+			updated_at = GREATEST(email_suppressions.updated_at, EXCLUDED.updated_at)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, email, reason, detail, provider, occurredAt)
+	return err
+}
+
+func (s *SuppressionStore) IsSuppressed(ctx context.Context, email string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM email_suppressions WHERE email = $1)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var suppressed bool
+	err := s.db.QueryRowContext(ctx, query, email).Scan(&suppressed)
+	return suppressed, err
+}
+
+// List returns the suppressions with the given reason, or all of them when
+// reason is empty.
+func (s *SuppressionStore) List(ctx context.Context, reason string, fq PaginatedFeedQuery) ([]*EmailSuppression, error) {
+	query := `
+		SELECT email, user_id, reason, detail, provider, events_count, created_at, updated_at
+		FROM email_suppressions
+		WHERE ($1 = '' OR reason = $1)
+		ORDER BY updated_at ` + sortDirection(fq.Sort) + `, email
+		LIMIT $2 OFFSET $3
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, reason, fq.Limit, fq.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suppressions := []*EmailSuppression{}
+	for rows.Next() {
+		e := &EmailSuppression{}
+		if err := rows.Scan(
+			&e.Email,
+			&e.UserID,
+			&e.Reason,
+			&e.Detail,
+			&e.Provider,
+			&e.EventsCount,
+			&e.CreatedAt,
+			&e.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		suppressions = append(suppressions, e)
+	}
+
+	return suppressions, rows.Err()
+}
+
+func (s *SuppressionStore) Delete(ctx context.Context, email string) error {
+	query := `DELETE FROM email_suppressions WHERE email = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, email)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,194 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// activeSuspension matches the suspensions that are not lifted nor over, a
+// suspension without end is a ban.
+const activeSuspension = `lifted_at IS NULL AND (ends_at IS NULL OR ends_at > NOW())`
+
+// hiddenAuthor filters out the content of users suspended with their content
+// hidden, the alias is the one of the table with the user_id column.
+func hiddenAuthor(alias string) string {
+	return `NOT EXISTS (
+		SELECT 1 FROM user_suspensions us
+		WHERE us.user_id = ` + alias + `.user_id AND us.hide_content AND ` + activeSuspension + `
+	)`
+}
+
+// Suspension keeps a user out of the app until it ends, or forever for bans.
+type Suspension struct {
+	ID          int64   `json:"id"`
+	UserID      int64   `json:"user_id"`
+	Reason      string  `json:"reason"`
+	EndsAt      *string `json:"ends_at"`
+	HideContent bool    `json:"hide_content"`
+	CreatedBy   *int64  `json:"created_by"`
+	CreatedAt   string  `json:"created_at"`
+	LiftedAt    *string `json:"lifted_at"`
+	LiftedBy    *int64  `json:"lifted_by"`
+}
+
+// IsBan reports whether the suspension never ends.
+func (s *Suspension) IsBan() bool {
+	return s.EndsAt == nil
+}
+
+type SuspensionStore struct {
+	db *sql.DB
+}
+
+const suspensionColumns = `id, user_id, reason, ends_at, hide_content, created_by, created_at, lifted_at, lifted_by`
+
+// Create lifts the active suspension of the user, if any, replacing it with
+// the new one and queues the email telling the user in the same transaction.
+func (s *SuspensionStore) Create(ctx context.Context, suspension *Suspension, email *OutboxEmail) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		query := `
+			UPDATE user_suspensions SET lifted_at = NOW(), lifted_by = $2
+			WHERE user_id = $1 AND ` + activeSuspension
+		if _, err := tx.ExecContext(ctx, query, suspension.UserID, suspension.CreatedBy); err != nil {
+			return err
+		}
+
+		query = `
+			INSERT INTO user_suspensions (user_id, reason, ends_at, hide_content, created_by)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id, created_at
+		`
+		err := tx.QueryRowContext(
+			ctx,
+			query,
+			suspension.UserID,
+			suspension.Reason,
+			suspension.EndsAt,
+			suspension.HideContent,
+			suspension.CreatedBy,
+		).Scan(
+			&suspension.ID,
+			&suspension.CreatedAt,
+		)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: insert or update on table "user_suspensions" violates foreign key constraint "user_suspensions_user_id_fkey"`:
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		return enqueueEmail(ctx, tx, email)
+	})
+}
+
+// GetActive returns the suspension the user is serving, it returns
+// ErrNotFound if the user is not suspended.
+func (s *SuspensionStore) GetActive(ctx context.Context, userID int64) (*Suspension, error) {
+	query := `
+		SELECT ` + suspensionColumns + `
+		FROM user_suspensions
+		WHERE user_id = $1 AND ` + activeSuspension + `
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	suspension := &Suspension{}
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(
+		&suspension.ID,
+		&suspension.UserID,
+		&suspension.Reason,
+		&suspension.EndsAt,
+		&suspension.HideContent,
+		&suspension.CreatedBy,
+		&suspension.CreatedAt,
+		&suspension.LiftedAt,
+		&suspension.LiftedBy,
+	)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return suspension, nil
+}
+
+// ListByUser returns every suspension of the user, lifted and over ones
+// included, the newest first.
+func (s *SuspensionStore) ListByUser(ctx context.Context, userID int64) ([]*Suspension, error) {
+	query := `
+		SELECT ` + suspensionColumns + `
+		FROM user_suspensions
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suspensions := []*Suspension{}
+	for rows.Next() {
+		suspension := &Suspension{}
+		if err := rows.Scan(
+			&suspension.ID,
+			&suspension.UserID,
+			&suspension.Reason,
+			&suspension.EndsAt,
+			&suspension.HideContent,
+			&suspension.CreatedBy,
+			&suspension.CreatedAt,
+			&suspension.LiftedAt,
+			&suspension.LiftedBy,
+		); err != nil {
+			return nil, err
+		}
+
+		suspensions = append(suspensions, suspension)
+	}
+
+	return suspensions, rows.Err()
+}
+
+// Lift ends the active suspension of the user right away, it returns
+// ErrNotFound if the user is not suspended.
+func (s *SuspensionStore) Lift(ctx context.Context, userID, liftedBy int64) error {
+	query := `
+		UPDATE user_suspensions SET lifted_at = NOW(), lifted_by = $2
+		WHERE user_id = $1 AND ` + activeSuspension
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, liftedBy)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
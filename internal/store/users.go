@@ -14,17 +14,27 @@ import (
 var (
 	ErrDuplicateEmail    = errors.New("email already exists")
 	ErrDuplicateUsername = errors.New("username already exists")
+	ErrInvalidSchool     = errors.New("school does not exist")
+	ErrNotActivated      = errors.New("account not activated")
 )
 
 type User struct {
-	ID        int64    `json:"id"`
-	Username  string   `json:"username"`
-	Email     string   `json:"email"`
-	Password  password `json:"-"`
-	CreatedAt string   `json:"created_at"`
-	IsActive  bool     `json:"-"`
-	Role      Role     `json:"-"`
-	RoleID    int64    `json:"-"`
+	ID          int64    `json:"id"`
+	Username    string   `json:"username"`
+	Email       string   `json:"email"`
+	DisplayName string   `json:"display_name"`
+	Bio         string   `json:"bio"`
+	SchoolID    *int64   `json:"school_id"`
+	Locale      string   `json:"locale"`
+	MFAEnabled  bool     `json:"mfa_enabled"`
+	Password    password `json:"-"`
+	CreatedAt   string   `json:"created_at"`
+	IsActive    bool     `json:"-"`
+	Role        Role     `json:"-"`
+	RoleID      int64    `json:"-"`
+	// TokenVersion is part of the JWT claims, bumping it invalidates
+	// every token issued before
+	TokenVersion int `json:"-"`
 }
 
 type password struct {
@@ -48,51 +58,21 @@ func (p *password) Compare(password string) error {
 	return bcrypt.CompareHashAndPassword(p.hash, []byte(password))
 }
 
-type UserStore struct {
-	db *sql.DB
+// IsSet is false for the users created from an OAuth provider, they can only
+// log in with the provider until they set a password.
+func (p *password) IsSet() bool {
+	return len(p.hash) > 0
 }
 
-func (s *UserStore) CreateOrUpdateUser(ctx context.Context, user *User) error {
-	query := `
-	INSERT INTO users (username, email, password, role_id, is_active) 
-	VALUES ($1, $2, $3, (SELECT id FROM roles WHERE name = $4), $5) 
-	ON CONFLICT (email) DO UPDATE 
-	SET username = EXCLUDED.username, is_active = EXCLUDED.is_active
-	RETURNING id, created_at
-	`
-
-	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
-	defer cancel()
-
-	role := user.Role.Name
-	if role == "" {
-		role = "user"
-	}
-
-	err := s.db.QueryRowContext(
-		ctx,
-		query,
-		user.Username,
-		user.Email,
-		user.Password.hash, // Puede que no necesites actualizar la contraseña aquí
-		role,
-		user.IsActive,
-	).Scan(
-		&user.ID,
-		&user.CreatedAt,
-	)
-	if err != nil {
-		return err
-	}
-
-	return nil
+type UserStore struct {
+	db *sql.DB
 }
 
 func (s *UserStore) Create(ctx context.Context, tx *sql.Tx, user *User) error {
 
 	query := `
-	INSERT INTO users (username, email, password, role_id) 
-	VALUES ($1, $2, $3, (SELECT id FROM roles WHERE name = $4)) 
+	INSERT INTO users (username, email, password, role_id, locale) 
+	VALUES ($1, $2, $3, (SELECT id FROM roles WHERE name = $4), $5) 
 	RETURNING id, created_at
 	`
 
@@ -111,6 +91,7 @@ func (s *UserStore) Create(ctx context.Context, tx *sql.Tx, user *User) error {
 		user.Email,
 		user.Password.hash,
 		role,
+		user.Locale,
 	).Scan(
 		&user.ID,
 		&user.CreatedAt,
@@ -129,12 +110,15 @@ func (s *UserStore) Create(ctx context.Context, tx *sql.Tx, user *User) error {
 	return nil
 }
 
+// GetUserByEmail returns the user even if it is not activated yet, callers
+// must check IsActive.
 func (s *UserStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
 	user := &User{}
 	query := `
-	SELECT id, username, password, email, created_at
+	SELECT id, username, password, email, display_name, bio, school_id, locale, created_at, token_version, is_active,
+	mfa_enabled
 	FROM users
-	WHERE email = $1 AND is_active = true
+	WHERE email = $1
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
@@ -145,7 +129,14 @@ func (s *UserStore) GetUserByEmail(ctx context.Context, email string) (*User, er
 		&user.Username,
 		&user.Password.hash,
 		&user.Email,
+		&user.DisplayName,
+		&user.Bio,
+		&user.SchoolID,
+		&user.Locale,
 		&user.CreatedAt,
+		&user.TokenVersion,
+		&user.IsActive,
+		&user.MFAEnabled,
 	)
 	if err != nil {
 		switch err {
@@ -162,7 +153,8 @@ func (s *UserStore) GetUserByEmail(ctx context.Context, email string) (*User, er
 func (s *UserStore) GetUserByID(ctx context.Context, userID int64) (*User, error) {
 	user := &User{}
 	query := `
-	SELECT u.id, username, password, email, created_at, r.* 
+	SELECT u.id, username, password, email, display_name, bio, u.school_id, locale, created_at,
+	token_version, mfa_enabled, r.id, r.name, r.level, r.description
 	FROM users u 
 	JOIN roles r ON u.role_id = r.id
 	WHERE u.id = $1 AND is_active = true
@@ -176,7 +168,13 @@ func (s *UserStore) GetUserByID(ctx context.Context, userID int64) (*User, error
 		&user.Username,
 		&user.Password.hash,
 		&user.Email,
+		&user.DisplayName,
+		&user.Bio,
+		&user.SchoolID,
+		&user.Locale,
 		&user.CreatedAt,
+		&user.TokenVersion,
+		&user.MFAEnabled,
 		&user.Role.ID,
 		&user.Role.Name,
 		&user.Role.Level,
@@ -194,7 +192,32 @@ func (s *UserStore) GetUserByID(ctx context.Context, userID int64) (*User, error
 	return user, nil
 }
 
-func (s *UserStore) CreateAndInvite(ctx context.Context, user *User, token string, invitationExp time.Duration) error {
+// CreateWithIdentity creates an active user without password that logs in
+// with the identity of a provider.
+func (s *UserStore) CreateWithIdentity(ctx context.Context, user *User, identity *Identity) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		if user.Password.hash == nil {
+			user.Password.hash = []byte{}
+		}
+
+		if err := s.Create(ctx, tx, user); err != nil {
+			return err
+		}
+
+		// the provider verified the email, no invitation is needed
+		user.IsActive = true
+		if err := s.update(ctx, tx, user); err != nil {
+			return err
+		}
+
+		identity.UserID = user.ID
+		return linkIdentity(ctx, tx, identity)
+	})
+}
+
+// CreateAndInvite creates the user with its invitation and queues the
+// welcome email in the same transaction.
+func (s *UserStore) CreateAndInvite(ctx context.Context, user *User, token string, invitationExp time.Duration, welcome *OutboxEmail) error {
 	return withTx(s.db, ctx, func(tx *sql.Tx) error {
 		if err := s.Create(ctx, tx, user); err != nil {
 			return err
@@ -205,10 +228,91 @@ func (s *UserStore) CreateAndInvite(ctx context.Context, user *User, token strin
 			return err
 		}
 
+		// queue the welcome email
+		if err := enqueueEmail(ctx, tx, welcome); err != nil {
+			return err
+		}
+
 		return nil
 	})
 }
 
+// RenewInvitation replaces the invitations of a not yet activated user with a
+// new one, it returns ErrNotFound if there is no inactive user with the email.
+func (s *UserStore) RenewInvitation(ctx context.Context, email, token string, invitationExp time.Duration) (*User, error) {
+	user := &User{}
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			SELECT id, username, email, locale
+			FROM users
+			WHERE email = $1 AND is_active = false
+			FOR UPDATE
+		`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		err := tx.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.Username, &user.Email, &user.Locale)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		if err := s.deleteUserInvitations(ctx, tx, user.ID); err != nil {
+			return err
+		}
+
+		return s.createUserInvitation(ctx, tx, token, invitationExp, user.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// DeleteUnactivated removes the accounts that were never activated and were
+// created before the retention period, it returns how many were removed.
+func (s *UserStore) DeleteUnactivated(ctx context.Context, retention time.Duration) (int64, error) {
+	var deleted int64
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		createdBefore := time.Now().Add(-retention)
+
+		query := `
+			DELETE FROM users_invitations
+			WHERE user_id IN (
+				SELECT id FROM users WHERE is_active = false AND created_at < $1
+			)
+		`
+		if _, err := tx.ExecContext(ctx, query, createdBefore); err != nil {
+			return err
+		}
+
+		query = `DELETE FROM users WHERE is_active = false AND created_at < $1`
+		res, err := tx.ExecContext(ctx, query, createdBefore)
+		if err != nil {
+			return err
+		}
+
+		deleted, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
 func (s *UserStore) createUserInvitation(ctx context.Context, tx *sql.Tx, token string, exp time.Duration, userID int64) error {
 	query := `
 	INSERT INTO users_invitations (token, expiry, user_id) 
@@ -277,6 +381,181 @@ func (s *UserStore) update(ctx context.Context, tx *sql.Tx, user *User) error {
 	return nil
 }
 
+func (s *UserStore) UpdateProfile(ctx context.Context, user *User) error {
+	query := `
+		UPDATE users SET username = $1, display_name = $2, bio = $3, school_id = $4, locale = $5
+		WHERE id = $6
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, user.Username, user.DisplayName, user.Bio, user.SchoolID, user.Locale, user.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_username_key"`:
+			return ErrDuplicateUsername
+		case err.Error() == `pq: insert or update on table "users" violates foreign key constraint "users_school_id_fkey"`:
+			return ErrInvalidSchool
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetRole changes the role of the user, it returns ErrNotFound if the user
+// doesn't exist.
+func (s *UserStore) SetRole(ctx context.Context, userID, roleID int64) error {
+	query := `UPDATE users SET role_id = $1 WHERE id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, roleID, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// UpdatePassword sets the new password and invalidates the tokens, sessions
+// and API keys issued with the old one, user gets the new token version.
+func (s *UserStore) UpdatePassword(ctx context.Context, user *User) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		query := `
+			UPDATE users SET password = $1, token_version = token_version + 1
+			WHERE id = $2
+			RETURNING token_version
+		`
+
+		err := tx.QueryRowContext(ctx, query, user.Password.hash, user.ID).Scan(&user.TokenVersion)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		if err := revokeSessions(ctx, tx, user.ID); err != nil {
+			return err
+		}
+
+		return revokeAPIKeys(ctx, tx, user.ID)
+	})
+}
+
+// CreateEmailChangeRequest replaces any pending email change of the user with
+// a new one that has to be confirmed with the token, the emails are queued in
+// the same transaction.
+func (s *UserStore) CreateEmailChangeRequest(ctx context.Context, userID int64, newEmail, token string, exp time.Duration, emails ...*OutboxEmail) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		var exists bool
+		query := `SELECT EXISTS (SELECT 1 FROM users WHERE email = $1)`
+		if err := tx.QueryRowContext(ctx, query, newEmail).Scan(&exists); err != nil {
+			return err
+		}
+
+		if exists {
+			return ErrDuplicateEmail
+		}
+
+		query = `DELETE FROM email_change_requests WHERE user_id = $1`
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+			return err
+		}
+
+		query = `
+			INSERT INTO email_change_requests (token, user_id, new_email, expiry)
+			VALUES ($1, $2, $3, $4)
+		`
+		if _, err := tx.ExecContext(ctx, query, token, userID, newEmail, time.Now().Add(exp)); err != nil {
+			return err
+		}
+
+		for _, e := range emails {
+			if err := enqueueEmail(ctx, tx, e); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// ConfirmEmailChange swaps the user email for the one of the request and
+// invalidates the tokens and sessions issued with the old email.
+func (s *UserStore) ConfirmEmailChange(ctx context.Context, token string) (*User, error) {
+	hash := sha256.Sum256([]byte(token))
+	hashToken := hex.EncodeToString(hash[:])
+
+	user := &User{}
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		query := `
+			UPDATE users u
+			SET email = ecr.new_email, token_version = u.token_version + 1
+			FROM email_change_requests ecr
+			WHERE ecr.token = $1 AND ecr.expiry > $2 AND u.id = ecr.user_id
+			RETURNING u.id, u.username, u.email
+		`
+
+		err := tx.QueryRowContext(ctx, query, hashToken, time.Now()).Scan(
+			&user.ID,
+			&user.Username,
+			&user.Email,
+		)
+		if err != nil {
+			switch {
+			case err == sql.ErrNoRows:
+				return ErrNotFound
+			case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+				return ErrDuplicateEmail
+			default:
+				return err
+			}
+		}
+
+		query = `DELETE FROM email_change_requests WHERE user_id = $1`
+		if _, err := tx.ExecContext(ctx, query, user.ID); err != nil {
+			return err
+		}
+
+		if err := revokeSessions(ctx, tx, user.ID); err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
 func (s *UserStore) getUserFromInvitation(ctx context.Context, tx *sql.Tx, token string) (*User, error) {
 	query := `
 		SELECT u.id, u.username, u.email, u.password, u.created_at, u.is_active
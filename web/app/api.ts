@@ -123,7 +123,7 @@ export const getNotes = async (professorId: number): Promise<Note[]> => {
         Authorization: `Bearer ${localStorage.getItem("token")}`,
       },
     });
-    return data.data as Note[];
+    return data.data.notes as Note[];
   } catch (error: any) {
     console.error(error);
     return [];